@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// acmeDNSAccount is a set of credentials for an acme-dns server,
+// see https://github.com/joohoi/acme-dns.
+type acmeDNSAccount struct {
+	Server     string `json:"server"` // acme-dns API base URL
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Subdomain  string `json:"subdomain"`
+	FullDomain string `json:"fulldomain"` // CNAME target of _acme-challenge
+}
+
+// readAcmeDNS reads acme-dns credentials from the JSON file at path.
+// The file maps domain names to accounts.
+func readAcmeDNS(path string) (map[string]*acmeDNSAccount, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]*acmeDNSAccount
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	res := make(map[string]*acmeDNSAccount, len(m))
+	for k, v := range m {
+		d, err := normalizeDomain(k)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		res[d] = v
+	}
+	return res, nil
+}
+
+// checkDelegation verifies the _acme-challenge record of domain
+// is a CNAME pointing to the acme-dns domain of a.
+func (a *acmeDNSAccount) checkDelegation(ctx context.Context, domain string) error {
+	name := "_acme-challenge." + domain
+	cname, err := dnsResolver.resolver().LookupCNAME(ctx, name)
+	if err != nil {
+		return fmt.Errorf("acme-dns: lookup %s: %v", name, err)
+	}
+	want := strings.TrimSuffix(strings.ToLower(a.FullDomain), ".")
+	if got := strings.TrimSuffix(strings.ToLower(cname), "."); got != want {
+		return fmt.Errorf("acme-dns: %s is not delegated to %s; add a CNAME record", name, a.FullDomain)
+	}
+	return nil
+}
+
+// update sets the TXT record of a to value.
+func (a *acmeDNSAccount) update(ctx context.Context, value string) error {
+	body, err := json.Marshal(map[string]string{
+		"subdomain": a.Subdomain,
+		"txt":       value,
+	})
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(a.Server, "/") + "/update"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-User", a.Username)
+	req.Header.Set("X-Api-Key", a.Password)
+	client := &http.Client{Transport: newTransport()}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("acme-dns: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("acme-dns: %s: %s", res.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
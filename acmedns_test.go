@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcmeDNSUpdate(t *testing.T) {
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/update" {
+			t.Errorf("%s %s; want POST /update", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"txt": "ok"}`))
+	}))
+	defer ts.Close()
+
+	a := &acmeDNSAccount{
+		Server:    ts.URL + "/",
+		Username:  "user",
+		Password:  "pass",
+		Subdomain: "sub",
+	}
+	if err := a.update(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+	if got["subdomain"] != "sub" || got["txt"] != "token" {
+		t.Errorf("request body = %v", got)
+	}
+
+	a.Password = "wrong"
+	if err := a.update(context.Background(), "token"); err == nil {
+		t.Error("update with wrong password: nil error")
+	}
+}
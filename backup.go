@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	cmdBackup = &command{
+		run:       runBackup,
+		UsageLine: "backup [-c config] [-certs] [-force] file",
+		Short:     "archive the account config",
+		Long: `
+Backup writes {{.AccountFile}} from the config dir and the keys of all
+accounts in it into a single gzipped tar archive, so that the accounts
+can be moved to another machine using the restore command.
+
+Account keys stored outside the config dir, or in a subdir of it,
+are put at the top of the archive, and the archived config refers
+to them there. Restoring the archive thus gives a config dir holding
+all the keys it needs.
+
+If -certs is specified, the certificates, their keys and metadata stored
+in the config dir are also included.
+
+The archive contains private keys and is created with 0600 mode.
+The command refuses to replace an existing file unless -force is specified.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	cmdRestore = &command{
+		run:       runRestore,
+		UsageLine: "restore [-c config] [-force] file",
+		Short:     "restore the account config from an archive",
+		Long: `
+Restore unpacks an archive created with the backup command into
+the config dir, creating the dir if it does not exist.
+
+The command refuses to overwrite any existing file in the config dir,
+such as the account config or a key, unless -force is specified.
+The whole archive is read and checked before any file is written,
+and each file is replaced atomically.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	backupCerts   bool
+	backupForce   bool
+	restoreForce  bool
+	backupMaxSize int64 = 1 << 20 // max size of a restored file
+)
+
+func init() {
+	cmdBackup.flag.BoolVar(&backupCerts, "certs", backupCerts, "")
+	cmdBackup.flag.BoolVar(&backupForce, "force", backupForce, "")
+	cmdRestore.flag.BoolVar(&restoreForce, "force", restoreForce, "")
+}
+
+func runBackup(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one output file")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	files, err := accountFiles()
+	if err != nil {
+		fatalf("backup: %v", err)
+	}
+	if backupCerts {
+		used := map[string]bool{journalFile: true}
+		for _, f := range files {
+			used[f.name] = true
+		}
+		for _, pat := range []string{"*.crt", "*.key", "*.json"} {
+			m, err := filepath.Glob(filepath.Join(configDir, pat))
+			if err != nil {
+				fatalf("%v", err)
+			}
+			for _, p := range m {
+				if n := filepath.Base(p); !used[n] {
+					files = append(files, archiveFile{name: n, path: p})
+				}
+			}
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if backupForce {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(args[0], flag, 0600)
+	if os.IsExist(err) {
+		fatalf("%s already exists; use -force to overwrite", args[0])
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := writeBackup(f, files); err != nil {
+		f.Close()
+		os.Remove(args[0])
+		fatalf("backup: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		fatalf("backup: %v", err)
+	}
+}
+
+// archiveFile is a file in a backup archive.
+type archiveFile struct {
+	name string // name in the archive
+	path string // file to archive, unless data is set
+	data []byte // contents of a file created for the archive, with 0600 mode
+}
+
+// accountFiles returns the config file and the keys of all accounts in it.
+// Keys which are not at the top of configDir are archived under their base
+// name, made unique, and the archived config is changed to refer to them.
+func accountFiles() ([]archiveFile, error) {
+	uc, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{configFile: true}
+	names := make(map[string]string) // key path to archive name
+	var files []archiveFile
+	addKey := func(a *userConfig) {
+		path := a.keyPath()
+		name, ok := names[path]
+		if !ok {
+			name = a.Key
+			if name == "" {
+				name = configKeyFile
+			}
+			if filepath.IsAbs(name) || name != filepath.Base(name) {
+				base := strings.TrimLeft(filepath.Base(path), ".")
+				name = base
+				for i := 1; used[name] || name == ""; i++ {
+					name = base + "." + strconv.Itoa(i)
+				}
+			}
+			used[name] = true
+			names[path] = name
+			files = append(files, archiveFile{name: name, path: path})
+		}
+		if a.Key != "" || name != configKeyFile {
+			a.Key = name
+		}
+	}
+	addKey(uc)
+	for _, a := range uc.Accounts {
+		addKey(a)
+	}
+	b, err := json.MarshalIndent(uc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]archiveFile{{name: configFile, data: b}}, files...), nil
+}
+
+// writeBackup writes files to w as a gzipped tar archive.
+func writeBackup(w io.Writer, files []archiveFile) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		if err := addFile(tw, f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func addFile(tw *tar.Writer, af archiveFile) error {
+	if af.data != nil {
+		h := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     af.name,
+			Mode:     0600,
+			Size:     int64(len(af.data)),
+			ModTime:  time.Now(),
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := tw.Write(af.data)
+		return err
+	}
+	f, err := os.Open(af.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	h.Name = af.name
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func runRestore(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one archive file")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer f.Close()
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		fatalf("%v", err)
+	}
+	if err := readBackup(f); err != nil {
+		fatalf("restore: %v", err)
+	}
+}
+
+// readBackup extracts files from a gzipped tar archive read from r
+// into configDir, preserving their permission bits.
+// The archive is read completely before any file is written, and existing
+// files are not replaced unless restoreForce is set.
+func readBackup(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	type file struct {
+		name string
+		mode os.FileMode
+		data []byte
+	}
+	var files []file
+	names := make(map[string]bool)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			// read to the end of the gzip stream to verify its checksum
+			if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// only plain files at the top level are expected
+		if h.Typeflag != tar.TypeReg || h.Name != filepath.Base(h.Name) ||
+			strings.HasPrefix(h.Name, ".") {
+			return fmt.Errorf("unexpected archive entry %q", h.Name)
+		}
+		if names[h.Name] {
+			return fmt.Errorf("duplicate archive entry %q", h.Name)
+		}
+		if h.Size > backupMaxSize {
+			return fmt.Errorf("%s: file too large", h.Name)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %v", h.Name, err)
+		}
+		names[h.Name] = true
+		files = append(files, file{h.Name, os.FileMode(h.Mode).Perm(), b})
+	}
+	if !restoreForce {
+		for _, f := range files {
+			path := filepath.Join(configDir, f.name)
+			if _, err := os.Lstat(path); err == nil {
+				return fmt.Errorf("%s already exists; use -force to overwrite", path)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	// write the config last, so that it never refers to missing keys
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].name != configFile && files[j].name == configFile
+	})
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(configDir, f.name), f.data, f.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src, err := ioutil.TempDir("", "acme-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	files := map[string]os.FileMode{
+		accountFile:   0600,
+		accountKey:    0600,
+		"example.crt": 0644,
+	}
+	for n, m := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, n), []byte(n), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = src
+	var buf bytes.Buffer
+	var list []archiveFile
+	for _, n := range []string{accountFile, accountKey, "example.crt"} {
+		list = append(list, archiveFile{name: n, path: filepath.Join(src, n)})
+	}
+	if err := writeBackup(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := ioutil.TempDir("", "acme-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	configDir = dst
+	if err := readBackup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for n, m := range files {
+		path := filepath.Join(dst, n)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("%s: %v", n, err)
+			continue
+		}
+		if fi.Mode().Perm() != m {
+			t.Errorf("%s: mode = %v; want %v", n, fi.Mode().Perm(), m)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != n {
+			t.Errorf("%s: content = %q; want %q", n, b, n)
+		}
+	}
+}
+
+func TestBackupKeyPaths(t *testing.T) {
+	src, err := ioutil.TempDir("", "acme-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	outside, err := ioutil.TempDir("", "acme-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = src
+
+	// the default account key is stored outside the config dir
+	// and the secondary account key in a subdir
+	keys := map[string]string{
+		"":                           filepath.Join(outside, accountKey),
+		"https://ca.example.org/dir": filepath.Join(src, "keys", accountKey),
+	}
+	uc := &userConfig{
+		CA:  "https://acme.example.org/dir",
+		Key: keys[""],
+		Accounts: map[string]*userConfig{
+			"https://ca.example.org/dir": {Key: filepath.Join("keys", accountKey)},
+		},
+	}
+	uc.URI = "https://acme.example.org/reg/1"
+	uc.Accounts["https://ca.example.org/dir"].URI = "https://ca.example.org/reg/2"
+	if err := writeConfig(uc); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeKey(path, k, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := accountFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeBackup(&buf, files); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := ioutil.TempDir("", "acme-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	configDir = dst
+	if err := readBackup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(ca discoAliasFlag) { configCA = ca }(configCA)
+	for ca, path := range keys {
+		configCA = discoAliasFlag(ca)
+		uc, err := readConfig()
+		if err != nil {
+			t.Fatalf("readConfig(%q): %v", ca, err)
+		}
+		if uc.key == nil {
+			t.Errorf("%q: no key restored at %s", ca, uc.keyPath())
+			continue
+		}
+		if dir := filepath.Dir(uc.keyPath()); dir != dst {
+			t.Errorf("%q: key restored to %s; want it in %s", ca, dir, dst)
+		}
+		orig, err := readKey(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameKey(uc.key, orig) {
+			t.Errorf("%q: restored key differs from %s", ca, path)
+		}
+	}
+}
+
+func TestRestoreRefusesOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, f bool) { configDir, restoreForce = d, f }(configDir, restoreForce)
+	configDir = dir
+	var buf bytes.Buffer
+	files := []archiveFile{
+		{name: accountFile, data: []byte("config")},
+		{name: accountKey, data: []byte("key")},
+	}
+	if err := writeBackup(&buf, files); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// an existing key is not replaced, and nothing else is written
+	key := filepath.Join(dir, accountKey)
+	if err := ioutil.WriteFile(key, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := readBackup(bytes.NewReader(archive)); err == nil {
+		t.Error("readBackup over an existing key: nil error")
+	}
+	if b, _ := ioutil.ReadFile(key); string(b) != "old" {
+		t.Errorf("existing key = %q; want it kept", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, accountFile)); !os.IsNotExist(err) {
+		t.Errorf("config written despite the refusal: %v", err)
+	}
+
+	// a truncated archive writes nothing either
+	os.Remove(key)
+	if err := readBackup(bytes.NewReader(archive[:len(archive)-8])); err == nil {
+		t.Error("readBackup of a truncated archive: nil error")
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 0 {
+		t.Errorf("truncated archive wrote %q", names)
+	}
+
+	restoreForce = true
+	if err := ioutil.WriteFile(key, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := readBackup(bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(key); string(b) != "key" {
+		t.Errorf("key with -force = %q; want %q", b, "key")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// bundleFile is one file of a certificate bundle.
+type bundleFile struct {
+	part string // "key", "cert", "chain" or "fullchain"
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+// bundleFiles returns the privkey.pem, cert.pem, chain.pem and fullchain.pem
+// files of the DER encoded cert chain and its PEM encoded key.
+// All four are computed from the same chain so they are always consistent.
+func bundleFiles(cert [][]byte, key []byte) []bundleFile {
+	var certs [][]byte
+	for _, b := range cert {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: x509PublicKey, Bytes: b}))
+	}
+	join := func(list [][]byte) []byte {
+		var res []byte
+		for _, b := range list {
+			res = append(res, b...)
+		}
+		return res
+	}
+	return []bundleFile{
+		{"key", "privkey.pem", key, 0600},
+		{"cert", "cert.pem", certs[0], 0644},
+		{"chain", "chain.pem", join(certs[1:]), 0644},
+		{"fullchain", "fullchain.pem", join(certs), 0644},
+	}
+}
+
+// installCert replaces the certificate at certPath with the DER encoded
+// cert chain, the key at keyPath with the key file newKey, unless it is
+// empty, and the bundle in certOutDir, if set, named after cn.
+// The chain and the key are verified to match first. If a step fails,
+// the files replaced by the previous steps are restored.
+// The replaced certificate and key are kept with a .bak suffix.
+func installCert(certPath, keyPath, newKey, cn string, cert [][]byte) (err error) {
+	if newKey == "" {
+		err = checkPair(cert, keyPath)
+	} else {
+		err = checkPair(cert, newKey)
+	}
+	if err != nil {
+		return err
+	}
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err1 := undo[i](); err1 != nil {
+				logf("rollback: %v", err1)
+			}
+		}
+	}()
+
+	old, err := ioutil.ReadFile(certPath)
+	switch {
+	case os.IsNotExist(err):
+		undo = append(undo, func() error { return os.Remove(certPath) })
+	case err != nil:
+		return fmt.Errorf("backup cert: %v", err)
+	default:
+		if err = backupFile(certPath); err != nil {
+			return fmt.Errorf("backup cert: %v", err)
+		}
+		undo = append(undo, func() error { return writeFileAtomic(certPath, old, 0644) })
+	}
+	if newKey != "" {
+		if err = os.Rename(keyPath, keyPath+".bak"); err == nil {
+			undo = append(undo, func() error { return os.Rename(keyPath+".bak", keyPath) })
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("backup key: %v", err)
+		}
+		if err = os.Rename(newKey, keyPath); err != nil {
+			return fmt.Errorf("move key: %v", err)
+		}
+		undo = append(undo, func() error { return os.Rename(keyPath, newKey) })
+	}
+	if err = writeCert(certPath, cert); err != nil {
+		return fmt.Errorf("write cert: %v", err)
+	}
+	if err = writeOutDir(certOutDir, cn, cert, keyPath); err != nil {
+		return fmt.Errorf("write out dir: %v", err)
+	}
+	return nil
+}
+
+// checkPair verifies that the key file at keyPath holds the key of the
+// first certificate in the DER encoded cert chain, and that each certificate
+// in the chain is signed by the next one.
+func checkPair(cert [][]byte, keyPath string) error {
+	var crts []*x509.Certificate
+	for _, b := range cert {
+		crt, err := x509.ParseCertificate(b)
+		if err != nil {
+			return fmt.Errorf("cert: %v", err)
+		}
+		crts = append(crts, crt)
+	}
+	if len(crts) == 0 {
+		return fmt.Errorf("cert: empty chain")
+	}
+	k, err := readKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("cert key: %v", err)
+	}
+	pub, ok := k.Public().(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok || !pub.Equal(crts[0].PublicKey) {
+		return fmt.Errorf("cert key %s does not match the certificate", keyPath)
+	}
+	for i := 0; i+1 < len(crts); i++ {
+		if err := crts[i].CheckSignatureFrom(crts[i+1]); err != nil {
+			return fmt.Errorf("chain: %q is not signed by %q: %v",
+				crts[i].Subject.CommonName, crts[i+1].Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// writeBundle writes files into dir. All files are staged next to
+// their destination first and renamed into place only once every one
+// was written, so a failure never leaves a mix of old and new files:
+// files renamed before a failed rename are restored.
+func writeBundle(dir string, files []bundleFile) error {
+	tmp := make([]string, 0, len(files))
+	defer func() {
+		for _, name := range tmp {
+			os.Remove(name)
+		}
+	}()
+	for _, bf := range files {
+		f, err := ioutil.TempFile(dir, bf.name+".tmp")
+		if err != nil {
+			return err
+		}
+		tmp = append(tmp, f.Name())
+		_, err = f.Write(bf.data)
+		if err == nil {
+			err = f.Chmod(bf.perm)
+		}
+		if err1 := f.Close(); err1 != nil && err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var restore []func()
+	for i, bf := range files {
+		path := filepath.Join(dir, bf.name)
+		if old, err := ioutil.ReadFile(path); err == nil {
+			perm := bf.perm
+			if fi, err := os.Stat(path); err == nil {
+				perm = fi.Mode().Perm()
+			}
+			restore = append(restore, func() { writeFileAtomic(path, old, perm) })
+		} else {
+			restore = append(restore, func() { os.Remove(path) })
+		}
+		if err := os.Rename(tmp[i], path); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				restore[j]()
+			}
+			return err
+		}
+	}
+	tmp = nil
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := bundleFiles([][]byte{[]byte("leaf"), []byte("inter")}, []byte("key"))
+	if err := writeBundle(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) []byte {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	full := append(read("cert.pem"), read("chain.pem")...)
+	if !bytes.Equal(read("fullchain.pem"), full) {
+		t.Errorf("fullchain.pem is not cert.pem followed by chain.pem")
+	}
+	for _, f := range files {
+		fi, err := os.Stat(filepath.Join(dir, f.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != f.perm {
+			t.Errorf("%s mode = %v; want %v", f.name, fi.Mode().Perm(), f.perm)
+		}
+	}
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(files) {
+		t.Errorf("%d files in dir; want %d", len(list), len(files))
+	}
+}
+
+func TestInstallCertRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { certOutDir = d }(certOutDir)
+
+	newPair := func(name string) (string, [][]byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1)}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := writeKey(path, key, nil); err != nil {
+			t.Fatal(err)
+		}
+		return path, [][]byte{der}
+	}
+	certPath := filepath.Join(dir, "example.org.crt")
+	keyPath, cert := newPair("example.org.key")
+	if err := writeCert(certPath, cert); err != nil {
+		t.Fatal(err)
+	}
+	oldCert, _ := ioutil.ReadFile(certPath)
+	oldKey, _ := ioutil.ReadFile(keyPath)
+	check := func(what string) {
+		if b, _ := ioutil.ReadFile(certPath); !bytes.Equal(b, oldCert) {
+			t.Errorf("%s: cert was replaced", what)
+		}
+		if b, _ := ioutil.ReadFile(keyPath); !bytes.Equal(b, oldKey) {
+			t.Errorf("%s: key was replaced", what)
+		}
+	}
+
+	newKey, newCert := newPair("new.key")
+	if err := installCert(certPath, keyPath, "", "example.org", newCert); err == nil {
+		t.Error("mismatched key: nil error")
+	}
+	check("mismatched key")
+
+	// a file in place of the out dir makes the last step fail
+	certOutDir = filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(certOutDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := installCert(certPath, keyPath, newKey, "example.org", newCert); err == nil {
+		t.Error("failed out dir: nil error")
+	}
+	check("failed out dir")
+	if _, err := os.Stat(newKey); err != nil {
+		t.Errorf("new key not moved back: %v", err)
+	}
+
+	certOutDir = ""
+	if err := installCert(certPath, keyPath, newKey, "example.org", newCert); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(keyPath + ".bak"); !bytes.Equal(b, oldKey) {
+		t.Error("old key not backed up")
+	}
+	if err := checkPair(newCert, keyPath); err != nil {
+		t.Errorf("installed pair: %v", err)
+	}
+}
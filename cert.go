@@ -12,18 +12,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -32,19 +40,134 @@ import (
 var (
 	cmdCert = &command{
 		run:       runCert,
-		UsageLine: "cert [-c config] [-d url] [-s host:port] [-k key] [-expiry dur] [-bundle=true] [-manual=false] [-dns=false] domain [domain ...]",
+		UsageLine: "cert [-c config] [-d url] [-s host:port] [-k key] [-out-dir dir | -stdout parts] [-separate [-keep-going]] [-add-domain name] [-remove-domain name] [-reuse-key=true] [-cert-curve P-256|P-384|P-521] [-key-format pkcs1|pkcs8] [-encrypt] [-cn domain] [-eku server|client|both] [-expiry dur] [-renew-at dur|pct%] [-force] [-bundle=true] [-include-root] [-manual=false] [-dns=false] [-challenge [domain=]type] [-challenge-fallback types] [-webroot [domain=]dir] [-acme-dns file] [-dns-propagation-timeout dur] [-dns-poll-interval dur] [-allow-shared-key] [-http-self-check [-self-check-addr host:port] [-self-check-proxy] [-self-check-attempts n] [-self-check-interval dur]] [-strict-chain [-roots file]] [-expect-issuer name] [-deploy-hook cmd] [-skip-hook cmd] [-print-jws] [-domains-file file] domain [domain ...]",
 		Short:     "request a new certificate",
 		Long: `
 Cert creates a new certificate for the given domain.
 It uses the http-01 challenge type by default and dns-01 if -dns is specified.
 
+The -challenge argument selects the challenge type, http-01 or dns-01,
+for all domains or, in domain=type form, for a single domain.
+It may be repeated, e.g. -challenge dns-01 -challenge www.example.org=http-01.
+Wildcard names can only be validated with dns-01.
+
+The -challenge-fallback argument is a comma-separated list of challenge types
+to try in turn for a domain whose selected challenge fails, such as when
+the local server cannot listen or the CA cannot validate the response.
+A failed validation makes the CA's authorization invalid, so a new one
+is requested for the next type. The type which succeeded for each domain
+is recorded in the domain.json file, and with -challenge-fallback
+a renewal tries it first.
+
 The certificate will be placed alongside key file, specified with -k argument.
 If the key file does not exist, a new one will be created.
 Default location for the key file is {{.ConfigDir}}/domain.key,
 where domain is the actually domain name provided as the command argument.
 
+New certificate keys are RSA 2048 bit keys, unless the -cert-curve argument
+selects an ECDSA key on the P-256, P-384 or P-521 curve instead. The curve
+is independent of the account key type. An existing key file is used as is,
+unless -reuse-key=false is specified, in which case renewing an existing
+certificate also replaces its key with a new one. The old key is then kept
+with a .bak suffix.
+
+New keys are written as PKCS#1 RSA PRIVATE KEY blocks for RSA keys, and as
+PKCS#8 PRIVATE KEY blocks for EC keys. The -key-format argument, pkcs1 or
+pkcs8, selects one format for both; pkcs1 then means a SEC 1 EC PRIVATE KEY
+block for EC keys. It also applies to the key printed with -stdout.
+
+With -encrypt, new certificate keys are stored as encrypted PKCS#8 keys,
+and the metadata file alongside the certificate is encrypted too, with
+the {{.KeyPassEnv}} passphrase, which must then be set; see acme help account.
+Encrypted metadata stays encrypted on later runs. The certificate itself
+is public and is not encrypted. Keys written to -out-dir or printed with
+-stdout are not encrypted, since servers read them, while -deploy-hook
+commands get the encrypted key file.
+
+Stored defaults for -reuse-key, -cert-curve and -challenge can be set
+with the defaults command. Flags given on the command line override them.
+
+The -out-dir argument additionally places copies of the key and certificate
+in a subdirectory of dir named after the domain, using the file names
+of Certbot: privkey.pem, cert.pem, chain.pem and fullchain.pem.
+
+The -stdout argument writes the obtained certificate to the standard output
+in PEM format instead, for use in pipelines. Nothing is written to disk, and
+the certificate is always issued, since no existing one is looked up.
+The value is a comma-separated list of parts to write, in order:
+key, cert, chain and fullchain, e.g. -stdout key,fullchain.
+A new key is generated unless an existing one is specified with -k.
+Prompts for manual challenges are then printed to the standard error.
+
+The -separate flag obtains a separate certificate for each argument instead,
+each with its own key in the config dir. An argument may list several
+comma-separated domains to share a certificate, named after the first one,
+e.g. -separate example.org,www.example.org example.net.
+A summary of the results is printed at the end. The command stops at the
+first failure unless -keep-going is specified, in which case the remaining
+certificates are still obtained. It exits with a non-zero code if any failed.
+A certificate not due for renewal is reported, but is not a failure.
+
+The -expiry argument requests the lifetime of the certificate, starting now,
+such as 168h for a short-lived certificate. The default is {{.CertExpiry}}.
+CAs may ignore the request and choose the lifetime themselves. If -expiry
+is specified and the obtained certificate has another lifetime, a warning
+is printed. A zero -expiry leaves the lifetime to the CA.
+
+All domains are requested as subject alternative names. The certificate
+request has no subject common name, which is deprecated, unless
+one of the domains is specified with -cn argument.
+
+The -eku argument requests the extended key usage of the certificate:
+server, client for TLS client authentication, or both. By default the
+CA decides. CAs may ignore the request, in which case a warning is printed.
+
+If the certificate file already exists, it is renewed only when due.
+The -renew-at argument specifies when a certificate is due, either as
+a duration before its expiry, e.g. 720h, or as a percentage of its total
+validity period, e.g. 66%. The default is {{.RenewAt}} before expiry.
+Use -force to renew regardless.
+The replaced certificate is kept with a .bak suffix.
+Before any file is replaced, the new key is checked to match the new
+certificate and each chain certificate to sign the one before it.
+The certificate, key and -out-dir files are then replaced together:
+if any of them fails, the ones already replaced are restored, and
+the -deploy-hook command only runs once all of them are in place.
+While a certificate is being obtained, a lock file with the .lock extension
+alongside it is locked, and another cert or rekey command for the same
+certificate fails. The lock is released when the command exits, even if
+it is killed, so a leftover lock file does not block later runs.
+The lock is advisory and only implemented on unix systems; elsewhere,
+such as on Windows, a warning is printed and concurrent runs are not
+prevented.
+
+The -domains-file argument reads more domains from a file, or from the
+standard input if it is "-", one per line, as if they were given as
+arguments after the command line ones. Blank lines and lines starting with #
+are ignored. With no domain arguments, the first domain in the file names
+the certificate. Manual challenges wait for enter on the standard input,
+so they need a file other than "-".
+
+Domain names are lowercased, converted to their punycode form and deduplicated.
+An existing certificate is renewed regardless of its expiry if it does not
+certify exactly the requested names.
+
+The names requested at each issuance, and the challenge type selected for
+each, are recorded in the domain.json file.
+The -add-domain and -remove-domain arguments, which may be repeated, renew
+an existing certificate with a changed set of names. The only domain
+argument then names the certificate, and the new set is the recorded one,
+or the names in the certificate if none are recorded, with the changes
+applied. The name of the certificate itself cannot be removed.
+
 By default the obtained certificate will also contain the CA chain.
 If this is undesired, specify -bundle=false argument.
+A self-signed root certificate at the end of the chain is removed, since
+servers should not send it to clients, unless -include-root is specified.
+
+With -strict-chain, the obtained chain is verified to lead from the certificate
+to a trusted root before anything is written. The roots are read from the PEM
+file specified with -roots, or the system roots are used.
 
 The -s argument specifies the address where to run local server
 for the http-01 challenge. If not specified, 127.0.0.1:8080 will be used.
@@ -52,182 +175,1393 @@ for the http-01 challenge. If not specified, 127.0.0.1:8080 will be used.
 An alternative to local server challenge response may be specified with -manual or -dns,
 in which case instructions are displayed on the standard output.
 
+The -webroot argument answers http-01 challenges by writing the response
+files under the .well-known/acme-challenge directory of an existing web
+server's document root instead. It is a dir for all domains, or in
+domain=dir form, a dir for a single domain. The form default=dir is
+the same as dir. It may be repeated for virtual hosts with different roots,
+e.g. -webroot default=/var/www -webroot example.org=/srv/example.
+A domain with no matching root, when there is no default, is an error.
+The files are removed once the challenge is done; see also acme help cleanup.
+
+The -acme-dns argument specifies a JSON file with acme-dns credentials,
+which are used to respond to dns-01 challenges without user interaction.
+The _acme-challenge record of each domain must be a CNAME pointing to
+its acme-dns fulldomain. The file maps domain names to credentials:
+
+	{"example.org": {"server": "https://auth.acme-dns.io",
+		"username": "...", "password": "...",
+		"subdomain": "...", "fulldomain": "....auth.acme-dns.io"}}
+
+Before a dns-01 challenge is accepted, the authoritative nameservers of the
+record's zone are polled every -dns-poll-interval until they all serve the
+TXT record, for at most -dns-propagation-timeout. The default is {{.DNSTimeout}}.
+A zero timeout disables the check.
+
+With -http-self-check, the http-01 challenge response is fetched
+before the CA is asked to validate it, failing early if it is not reachable.
+The -self-check-addr argument sends the self-check request to the given
+host:port instead of the address the domain resolves to, which is useful
+on multi-homed hosts. The self-check bypasses the proxy used for requests
+to the CA, unless -self-check-proxy is specified.
+A failed self-check is retried, since a just started server or a just
+written file may not be visible immediately. It is attempted at most
+-self-check-attempts times, {{.SelfCheckAttempts}} by default, waiting
+-self-check-interval, {{.SelfCheckInterval}} by default, before the first
+retry and doubling the wait for each one after.
+
+After writing the certificate, the SHA-256 of the certificate chain
+and key files is recorded in a domain.json file alongside the certificate.
+See also acme help hash.
+
+The common name of the issuer at the top of the chain, usually the root,
+is also recorded there when the certificate is first obtained.
+A warning is printed if a renewed certificate is not issued under the same
+name, which indicates the CA switched roots. The -expect-issuer argument
+makes this an error instead: the new certificate must have the named
+issuer somewhere in its chain, or it is discarded and the existing
+certificate is left in place.
+
+The -deploy-hook argument specifies a shell command run afterwards,
+with ACME_CERT and ACME_KEY environment variables set to the certificate
+and key file paths. The hook is skipped if it has already succeeded
+for the same certificate and key.
+
+The -skip-hook argument specifies a shell command run instead when
+the certificate is not due for renewal, e.g. to report to monitoring that
+the renewal ran. The ACME_CERT and ACME_DAYS_LEFT environment variables are
+set to the certificate file path and the number of days until it expires.
+
+The -print-jws flag prints each signed request sent to the CA
+to the standard error, with the JWS protected header and payload decoded,
+for debugging CA interoperability issues. The account key is never printed.
+
+The command refuses to use the account key as the certificate key,
+since compromise of the certificate key would then also compromise the account.
+Specify -allow-shared-key to override this check.
+
 Default location of the config dir is
 {{.ConfigDir}}.
 `,
 	}
 
-	certDisco   = defaultDiscoFlag
-	certAddr    = "127.0.0.1:8080"
-	certExpiry  = 365 * 12 * time.Hour
-	certBundle  = true
-	certManual  = false
-	certDNS     = false
-	certKeypath string
+	certDisco     = defaultDiscoFlag
+	certRenewAt   = renewAtFlag{before: 24 * 7 * 3 * time.Hour}
+	certAddr      = "127.0.0.1:8080"
+	certExpiry    = 365 * 12 * time.Hour
+	certCN        string
+	certEKU       ekuFlag
+	certBundle    = true
+	certRoot      = false
+	certManual    = false
+	certDNS       = false
+	certChallenge = challengeFlag{}
+	certWebroot   = webrootFlag{}
+	certShared    = false
+	certForce     = false
+	certKeypath   string
+	certOutDir    string
+	certStdout    string
+	certSeparate  bool
+	certKeepGoing bool
+	certCurve     curveFlag
+	certReuseKey  = true
+	certIssuer    string
+	certFlags     *flag.FlagSet // cmdCert.flag, to tell which flags are set
+
+	certEncrypt bool
+
+	certAddDomains    domainListFlag
+	certRemoveDomains domainListFlag
+	certDomainsFile   string
+
+	certFallback       challengeListFlag
+	certPreferred      map[string]string // domain to challenge type tried first
+	certUsedChallenges = map[string]string{}
+
+	certSelfCheck      = false
+	certSelfCheckAddr  string
+	certSelfCheckProxy bool
+
+	certSelfCheckAttempts = 3
+	certSelfCheckInterval = time.Second
+
+	certStrictChain = false
+	certRoots       string
+
+	certDeployHook string
+	certSkipHook   string
+
+	certDNSTimeout = 2 * time.Minute
+	certDNSPoll    = 5 * time.Second
+
+	certAcmeDNSFile string
+	certAcmeDNS     map[string]*acmeDNSAccount // loaded from certAcmeDNSFile
+
+	// promptOut is where instructions for manual challenges are printed.
+	promptOut io.Writer = os.Stdout
 )
 
 func init() {
 	cmdCert.flag.Var(&certDisco, "d", "")
 	cmdCert.flag.StringVar(&certAddr, "s", certAddr, "")
 	cmdCert.flag.DurationVar(&certExpiry, "expiry", certExpiry, "")
+	cmdCert.flag.StringVar(&certCN, "cn", "", "")
+	cmdCert.flag.Var(&certEKU, "eku", "")
+	cmdCert.flag.Var(&certRenewAt, "renew-at", "")
+	cmdCert.flag.BoolVar(&certForce, "force", certForce, "")
 	cmdCert.flag.BoolVar(&certBundle, "bundle", certBundle, "")
+	cmdCert.flag.BoolVar(&certRoot, "include-root", certRoot, "")
 	cmdCert.flag.BoolVar(&certManual, "manual", certManual, "")
 	cmdCert.flag.BoolVar(&certDNS, "dns", certDNS, "")
+	cmdCert.flag.Var(certChallenge, "challenge", "")
+	cmdCert.flag.Var(certWebroot, "webroot", "")
+	cmdCert.flag.BoolVar(&certShared, "allow-shared-key", certShared, "")
 	cmdCert.flag.StringVar(&certKeypath, "k", "", "")
+	cmdCert.flag.StringVar(&certOutDir, "out-dir", "", "")
+	cmdCert.flag.StringVar(&certStdout, "stdout", "", "")
+	cmdCert.flag.BoolVar(&certSeparate, "separate", certSeparate, "")
+	cmdCert.flag.BoolVar(&certKeepGoing, "keep-going", certKeepGoing, "")
+	cmdCert.flag.Var(&certAddDomains, "add-domain", "")
+	cmdCert.flag.Var(&certRemoveDomains, "remove-domain", "")
+	cmdCert.flag.StringVar(&certDomainsFile, "domains-file", "", "")
+	cmdCert.flag.Var(&certFallback, "challenge-fallback", "")
+	cmdCert.flag.Var(&certCurve, "cert-curve", "")
+	cmdCert.flag.Var(&keyFormat, "key-format", "")
+	cmdCert.flag.BoolVar(&certEncrypt, "encrypt", certEncrypt, "")
+	cmdCert.flag.BoolVar(&certReuseKey, "reuse-key", certReuseKey, "")
+	cmdCert.flag.StringVar(&certIssuer, "expect-issuer", "", "")
+	certFlags = &cmdCert.flag
+	cmdCert.flag.BoolVar(&certSelfCheck, "http-self-check", certSelfCheck, "")
+	cmdCert.flag.StringVar(&certSelfCheckAddr, "self-check-addr", "", "")
+	cmdCert.flag.BoolVar(&certSelfCheckProxy, "self-check-proxy", certSelfCheckProxy, "")
+	cmdCert.flag.IntVar(&certSelfCheckAttempts, "self-check-attempts", certSelfCheckAttempts, "")
+	cmdCert.flag.DurationVar(&certSelfCheckInterval, "self-check-interval", certSelfCheckInterval, "")
+	cmdCert.flag.BoolVar(&certStrictChain, "strict-chain", certStrictChain, "")
+	cmdCert.flag.StringVar(&certRoots, "roots", "", "")
+	cmdCert.flag.StringVar(&certAcmeDNSFile, "acme-dns", "", "")
+	cmdCert.flag.DurationVar(&certDNSTimeout, "dns-propagation-timeout", certDNSTimeout, "")
+	cmdCert.flag.DurationVar(&certDNSPoll, "dns-poll-interval", certDNSPoll, "")
+	cmdCert.flag.StringVar(&certDeployHook, "deploy-hook", "", "")
+	cmdCert.flag.StringVar(&certSkipHook, "skip-hook", "", "")
+	cmdCert.flag.BoolVar(&clientPrintJWS, "print-jws", clientPrintJWS, "")
+}
+
+// setupChallenge validates the challenge and key flags common to the commands
+// issuing certificates, and loads the resources they refer to.
+func setupChallenge() {
+	if certEncrypt && os.Getenv(keyPassEnv) == "" {
+		fatalf("-encrypt requires %s to be set to the passphrase", keyPassEnv)
+	}
+	if certAcmeDNSFile != "" {
+		m, err := readAcmeDNS(certAcmeDNSFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		certAcmeDNS = m
+		certDNS = true
+	}
+	if certManual && certDNS {
+		fatalf("-dns and -manual are mutually exclusive, only one should be specified")
+	}
+	if certManual && len(certWebroot) > 0 {
+		fatalf("-webroot and -manual are mutually exclusive, only one should be specified")
+	}
 }
 
 func runCert(args []string) {
+	if certDomainsFile != "" {
+		list, err := readDomainsFile(certDomainsFile)
+		if err != nil {
+			fatalf("-domains-file: %v", err)
+		}
+		args = append(args, list...)
+	}
 	if len(args) == 0 {
 		fatalf("no domain specified")
 	}
-	if certManual && certDNS {
-		fatalf("-dns and -manual are mutually exclusive, only one should be specified")
+	setupChallenge()
+	if certSeparate {
+		certEach(args)
+		return
+	}
+	cn, err := normalizeDomain(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	domains, err := normalizeDomains(args)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(certAddDomains) != 0 || len(certRemoveDomains) != 0 {
+		if len(args) != 1 || certStdout != "" {
+			fatalf("-add-domain and -remove-domain require a single domain argument naming an existing certificate, and no -stdout")
+		}
+		if err := checkConfigDir(); err != nil {
+			fatalf("%v", err)
+		}
+		if certKeypath == "" {
+			certKeypath = filepath.Join(configDir, cn+".key")
+		}
+		if domains, err = certChangeDomains(sameDir(certKeypath, cn+".crt"), cn); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if certCN != "" {
+		if certCN, err = normalizeDomain(certCN); err != nil {
+			fatalf("-cn: %v", err)
+		}
+		if i := sort.SearchStrings(domains, certCN); i == len(domains) || domains[i] != certCN {
+			fatalf("-cn %s is not one of the requested domains", certCN)
+		}
+	}
+	if certStdout != "" {
+		certToStdout(domains)
+		return
+	}
+	if certKeepGoing {
+		fatalf("-keep-going requires -separate")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
 	}
-	cn := args[0]
 	if certKeypath == "" {
 		certKeypath = filepath.Join(configDir, cn+".key")
 	}
+	if err := obtainCert(cn, domains, certKeypath); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// certChangeDomains returns the names of the certificate at certPath,
+// named after cn, changed with -add-domain and -remove-domain.
+func certChangeDomains(certPath, cn string) ([]string, error) {
+	m, err := readMeta(certPath)
+	if err != nil {
+		return nil, err
+	}
+	base := m.Domains
+	if len(base) == 0 {
+		crt, err := readCrt(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("-add-domain and -remove-domain require an existing certificate: %v", err)
+		}
+		base = certDomains(crt)
+	}
+	return changeDomains(base, cn, certAddDomains, certRemoveDomains)
+}
+
+// errNotDue is returned by obtainCert when the existing certificate
+// is not due for renewal.
+type errNotDue time.Time
+
+func (e errNotDue) Error() string {
+	return fmt.Sprintf("cert is not due for renewal until %s, not renewing", time.Time(e).Format(time.RFC3339))
+}
 
+// obtainCert obtains a certificate for domains, named after cn, using the key
+// at keyPath, unless an existing one with the same domains is not yet due.
+// The certificate is written alongside the key, and deployed.
+func obtainCert(cn string, domains []string, keyPath string) error {
 	// get user config
 	uc, err := readConfig()
 	if err != nil {
-		fatalf("read config: %v", err)
+		return fmt.Errorf("read config: %v", err)
 	}
 	if uc.key == nil {
-		fatalf("no key found for %s", uc.URI)
+		return fmt.Errorf("no key found for %s", uc.URI)
+	}
+	if err := applyDefaults(certFlags, uc.Defaults); err != nil {
+		return err
 	}
 
 	// read crt if existent
-	certPath := sameDir(certKeypath, cn+".crt")
+	certPath := sameDir(keyPath, cn+".crt")
+	if err := lockCert(certPath); err != nil {
+		return err
+	}
 	certCrt, err := readCrt(certPath)
+	if err == nil && !certForce && sameDomains(certDomains(certCrt), domains) {
+		// do not re-issue certificate if it's not about to expire
+		if t := certRenewAt.renewAt(certCrt); time.Now().Before(t) {
+			if certSkipHook != "" {
+				left := days(certCrt.NotAfter.Sub(time.Now()))
+				err := runHook(certSkipHook, "ACME_CERT="+certPath, fmt.Sprintf("ACME_DAYS_LEFT=%d", left))
+				if err != nil {
+					errorf("skip hook: %v", err)
+				}
+			}
+			return errNotDue(t)
+		}
+	}
+
+	// read or generate new cert key; a replacement key is moved into place
+	// only once the cert is issued
+	keyFile := keyPath
+	newKey := certCrt != nil && !certReuseKey
+	if newKey {
+		keyFile = keyPath + ".new"
+		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	certKey, err := anyKey(keyFile, true, certKeySpec())
+	if err != nil {
+		return fmt.Errorf("cert key: %v", err)
+	}
+	if !certShared && sameKey(certKey, uc.key) {
+		return fmt.Errorf("cert key %s is the account key; use -allow-shared-key to override", keyPath)
+	}
+
+	// initialize acme client and get the cert
+	dir := string(certDisco)
+	if configCA != "" {
+		dir = uc.CA
+	}
+	client := newClient(uc.key, dir)
+	// an interrupt aborts the flow, cleaning up the current challenge
+	ctx, stop := withSignals(context.Background())
+	defer stop()
+	if m, err := readMeta(certPath); err == nil {
+		certPreferred = m.Challenges
+	}
+	cert, err := issueCert(ctx, client, certCN, domains, certKey)
 	if err == nil {
-		// do not re-issue certificate if it's not about to expire in less than three weeks
-		expiresIn := certCrt.NotAfter.Sub(time.Now())
-		if expiresIn > 24*7*3*time.Hour {
-			errorf("cert is still valid for more than a three weeks, not renewing")
-			exit()
+		err = checkIssuer(certPath, cert)
+	}
+	if err != nil {
+		if newKey {
+			os.Remove(keyFile)
+		}
+		return err
+	}
+	newKeyFile := ""
+	if newKey {
+		newKeyFile = keyFile
+	}
+	if err := installCert(certPath, keyPath, newKeyFile, cn, cert); err != nil {
+		if newKey {
+			os.Remove(keyFile)
+		}
+		return err
+	}
+	if err := recordIssuance(certPath, domains); err != nil {
+		return fmt.Errorf("write meta: %v", err)
+	}
+	if err := deploy(certPath, keyPath); err != nil {
+		return fmt.Errorf("deploy: %v", err)
+	}
+	return nil
+}
+
+// recordIssuance records domains, and the challenge type which succeeded
+// for each, in the metadata of the certificate at certPath.
+// For a domain which needed no challenge, the previously recorded type
+// is kept, or else the selected one is recorded.
+func recordIssuance(certPath string, domains []string) error {
+	m, err := readMeta(certPath)
+	if err != nil {
+		return err
+	}
+	prev := m.Challenges
+	m.Domains = domains
+	m.Challenges = make(map[string]string, len(domains))
+	for _, d := range domains {
+		t := certUsedChallenges[d]
+		if t == "" {
+			t = prev[d]
+		}
+		if t == "" {
+			t, _ = challengeType(d)
+		}
+		if t != "" {
+			m.Challenges[d] = t
+		}
+	}
+	return writeMeta(certPath, m)
+}
+
+// certEach obtains a separate certificate for each of args, as requested
+// with -separate, and prints a summary of the results. An argument may list
+// several comma-separated domains sharing a certificate, named after the first.
+// Unless certKeepGoing is set, it stops at the first failure.
+func certEach(args []string) {
+	if certKeypath != "" || certCN != "" || certStdout != "" || len(certAddDomains) != 0 || len(certRemoveDomains) != 0 {
+		fatalf("-separate cannot be used with -k, -cn, -stdout, -add-domain or -remove-domain")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	type result struct {
+		cn  string
+		err error
+	}
+	var res []result
+	for _, a := range args {
+		names := strings.Split(a, ",")
+		cn, err := normalizeDomain(names[0])
+		if err != nil {
+			fatalf("%v", err)
 		}
+		domains, err := normalizeDomains(names)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		res = append(res, result{cn, obtainCert(cn, domains, filepath.Join(configDir, cn+".key"))})
+		if err := res[len(res)-1].err; err != nil {
+			if _, ok := err.(errNotDue); !ok && !certKeepGoing {
+				break
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tRESULT")
+	for _, r := range res {
+		status := "issued"
+		switch r.err.(type) {
+		case nil:
+		case errNotDue:
+			status = "not due"
+		default:
+			status = "failed: " + r.err.Error()
+			setExitStatus(1)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.cn, status)
+	}
+	for _, a := range args[len(res):] {
+		fmt.Fprintf(tw, "%s\t%s\n", a, "not attempted")
 	}
+	tw.Flush()
+}
 
-	// read or generate new cert key
-	certKey, err := anyKey(certKeypath, true)
+// certToStdout obtains a certificate for domains and writes the parts
+// selected with certStdout to the standard output, instead of files.
+// The key is read from certKeypath, if set, or generated.
+func certToStdout(domains []string) {
+	if certOutDir != "" {
+		fatalf("-stdout and -out-dir are mutually exclusive")
+	}
+	parts := strings.Split(certStdout, ",")
+	for _, p := range parts {
+		switch p {
+		case "key", "cert", "chain", "fullchain":
+		default:
+			fatalf("-stdout: unknown part %q", p)
+		}
+	}
+	// keep the output parseable
+	promptOut = os.Stderr
+
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	if err := applyDefaults(certFlags, uc.Defaults); err != nil {
+		fatalf("%v", err)
+	}
+	var certKey crypto.Signer
+	if certKeypath != "" {
+		certKey, err = readKey(certKeypath)
+	} else {
+		certKey, err = certCurve.keySpec().generate()
+	}
 	if err != nil {
 		fatalf("cert key: %v", err)
 	}
-	// generate CSR now to fail early in case of an error
-	req := &x509.CertificateRequest{
-		Subject: pkix.Name{CommonName: cn},
+	if !certShared && sameKey(certKey, uc.key) {
+		fatalf("cert key %s is the account key; use -allow-shared-key to override", certKeypath)
 	}
-	if len(args) > 1 {
-		req.DNSNames = args
+
+	dir := string(certDisco)
+	if configCA != "" {
+		dir = uc.CA
 	}
-	csr, err := x509.CreateCertificateRequest(rand.Reader, req, certKey)
+	client := newClient(uc.key, dir)
+	ctx, stop := withSignals(context.Background())
+	defer stop()
+	cert, err := issueCert(ctx, client, certCN, domains, certKey)
 	if err != nil {
-		fatalf("csr: %v", err)
+		fatalf("%v", err)
+	}
+
+	b, err := keyPEM(certKey, nil)
+	if err != nil {
+		fatalf("cert key: %v", err)
+	}
+	files := bundleFiles(cert, pem.EncodeToMemory(b))
+	var out []byte
+	for _, p := range parts {
+		for _, f := range files {
+			if f.part == p {
+				out = append(out, f.data...)
+			}
+		}
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// deploy records the bundle hash of the certificate at certPath and its key
+// in the certificate metadata, and runs certDeployHook, if any,
+// unless the same bundle was already deployed.
+func deploy(certPath, keyPath string) error {
+	m, err := readMeta(certPath)
+	if err != nil {
+		return err
+	}
+	if m.Hash, err = bundleHash(certPath, keyPath); err != nil {
+		return err
+	}
+	if certDeployHook != "" && m.Hash != m.Deployed {
+		err = runHook(certDeployHook, "ACME_CERT="+certPath, "ACME_KEY="+keyPath)
+		if err != nil {
+			err = fmt.Errorf("hook: %v", err)
+		} else {
+			m.Deployed = m.Hash
+		}
 	}
+	if err1 := writeMeta(certPath, m); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
 
-	// initialize acme client and start authz flow
-	// we only look for http-01 challenges at the moment
-	client := &acme.Client{
-		Key:          uc.key,
-		DirectoryURL: string(certDisco),
+// checkIssuer verifies the chain of the DER encoded cert, to be stored at
+// certPath, contains certIssuer, if set, or else the issuer recorded in
+// the metadata, in which case only a warning is logged. The issuer at the
+// top of the chain is recorded if none is yet.
+func checkIssuer(certPath string, cert [][]byte) error {
+	names, err := chainIssuers(cert)
+	if err != nil {
+		return err
+	}
+	m, err := readMeta(certPath)
+	if err != nil {
+		return err
+	}
+	has := func(name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if certIssuer != "" && !has(certIssuer) {
+		return fmt.Errorf("expected issuer %q not in the chain %q; certificate discarded", certIssuer, names)
+	}
+	if m.Issuer == "" {
+		m.Issuer = names[len(names)-1]
+		return writeMeta(certPath, m)
+	}
+	if !has(m.Issuer) {
+		logf("warning: issuer %q recorded at first issuance is not in the chain %q", m.Issuer, names)
 	}
-	for _, domain := range args {
-		ctx, cancel := context.Background(), func() {}
-		if !certManual && !certDNS {
-			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+	return nil
+}
+
+// chainIssuers returns the issuer common names of the DER encoded cert
+// chain, from the leaf's issuer up.
+func chainIssuers(cert [][]byte) ([]string, error) {
+	var names []string
+	for _, b := range cert {
+		crt, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, err
 		}
-		if err := authz(ctx, client, domain); err != nil {
-			fatalf("%s: %v", domain, err)
+		if n := crt.Issuer.CommonName; len(names) == 0 || names[len(names)-1] != n {
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
+
+// expiryWarning returns a warning if crt expires more than an hour away
+// from the requested notAfter time, or an empty string.
+func expiryWarning(crt *x509.Certificate, notAfter time.Time) string {
+	d := crt.NotAfter.Sub(notAfter)
+	if d > -time.Hour && d < time.Hour {
+		return ""
+	}
+	return fmt.Sprintf("the CA did not honor -expiry; the certificate is valid until %s, for %d days",
+		crt.NotAfter.Format(time.RFC3339), days(crt.NotAfter.Sub(crt.NotBefore)))
+}
+
+// issueCert authorizes the client for each of the domains and requests
+// a certificate for the key. The cn is the subject common name, if not empty.
+// The result contains the certificate and, if certBundle is true,
+// the CA chain, in DER format.
+// The root is removed from the chain unless certRoot is true.
+// The extended key usages selected with certEKU are requested.
+func issueCert(ctx context.Context, client *acme.Client, cn string, domains []string, key crypto.Signer) ([][]byte, error) {
+	// generate CSR now to fail early in case of an error
+	exts, err := certEKU.extensions()
+	if err != nil {
+		return nil, fmt.Errorf("csr: %v", err)
+	}
+	req := &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: cn},
+		DNSNames:        domains,
+		ExtraExtensions: exts,
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, req, key)
+	if err != nil {
+		return nil, fmt.Errorf("csr: %v", err)
+	}
+
+	// start authz flow
+	for _, domain := range domains {
+		if err := authzDomain(ctx, client, domain); err != nil {
+			return nil, fmt.Errorf("%s: %v", domain, err)
 		}
-		cancel()
 	}
 
 	// challenge fulfilled: get the cert
 	// wait at most 30 min
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
+	notAfter := time.Now().Add(certExpiry)
 	cert, curl, err := client.CreateCert(ctx, csr, certExpiry, certBundle)
 	if err != nil {
-		fatalf("cert: %v", err)
+		return nil, fmt.Errorf("cert: %v", err)
 	}
 	logf("cert url: %s", curl)
+	crt, err := x509.ParseCertificate(cert[0])
+	if err != nil {
+		return nil, fmt.Errorf("cert: %v", err)
+	}
+	if err := certEKU.check(crt); err != nil {
+		logf("warning: %v", err)
+	}
+	if certExpiry > 0 && certFlags != nil && setFlags(certFlags)["expiry"] {
+		if s := expiryWarning(crt, notAfter); s != "" {
+			logf("warning: %s", s)
+		}
+	}
+	if certStrictChain {
+		if err := verifyChain(cert, domains[0]); err != nil {
+			return nil, err
+		}
+	}
+	if !certRoot {
+		cert = trimRoot(cert)
+	}
+	return cert, nil
+}
+
+// trimRoot returns cert without its last element if that is
+// a self-signed certificate other than the leaf.
+// Certificates which fail to parse are left in place.
+func trimRoot(cert [][]byte) [][]byte {
+	if len(cert) < 2 {
+		return cert
+	}
+	c, err := x509.ParseCertificate(cert[len(cert)-1])
+	if err != nil {
+		return cert
+	}
+	if !bytes.Equal(c.RawIssuer, c.RawSubject) || c.CheckSignatureFrom(c) != nil {
+		return cert
+	}
+	return cert[:len(cert)-1]
+}
+
+// verifyChain verifies that the DER encoded leaf certificate cert[0]
+// chains up to a trusted root through the rest of cert.
+// The roots are read from certRoots file, if specified, or the system pool.
+// The resolved chain is logged.
+func verifyChain(cert [][]byte, domain string) error {
+	crts := make([]*x509.Certificate, len(cert))
+	for i, b := range cert {
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return fmt.Errorf("chain: %v", err)
+		}
+		crts[i] = c
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       domain,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range crts[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if certRoots != "" {
+		roots, err := readCerts(certRoots)
+		if err != nil {
+			return fmt.Errorf("roots: %v", err)
+		}
+		opts.Roots = x509.NewCertPool()
+		for _, c := range roots {
+			opts.Roots.AddCert(c)
+		}
+	}
+	chains, err := crts[0].Verify(opts)
+	if err != nil {
+		return fmt.Errorf("chain: %v", err)
+	}
+	names := make([]string, len(chains[0]))
+	for i, c := range chains[0] {
+		names[i] = c.Subject.CommonName
+	}
+	logf("chain: %s", strings.Join(names, " <- "))
+	return nil
+}
+
+// writeCert writes the DER encoded cert chain to path in PEM format.
+// The file is replaced atomically.
+func writeCert(path string, cert [][]byte) error {
 	var pemcert []byte
 	for _, b := range cert {
 		b = pem.EncodeToMemory(&pem.Block{Type: x509PublicKey, Bytes: b})
 		pemcert = append(pemcert, b...)
 	}
-	if err := ioutil.WriteFile(certPath, pemcert, 0644); err != nil {
-		fatalf("write cert: %v", err)
+	return writeFileAtomic(path, pemcert, 0644)
+}
+
+// writeOutDir writes the key at keyPath and the DER encoded cert chain
+// into a subdirectory of dir named domain, as separate privkey.pem,
+// cert.pem, chain.pem and fullchain.pem files, replacing them together.
+// It does nothing if dir is empty.
+func writeOutDir(dir, domain string, cert [][]byte, keyPath string) error {
+	if dir == "" {
+		return nil
+	}
+	dir = filepath.Join(dir, domain)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	if enc, err := isEncryptedKey(keyPath); err == nil && enc {
+		// servers read the key, so it is written decrypted
+		k, err := readKey(keyPath)
+		if err != nil {
+			return err
+		}
+		b, err := keyPEM(k, nil)
+		if err != nil {
+			return err
+		}
+		key = pem.EncodeToMemory(b)
+	}
+	return writeBundle(dir, bundleFiles(cert, key))
+}
+
+// renewAtFlag is a flag specifying when a certificate is due for renewal.
+// It accepts either a duration before expiry or a percentage of the
+// certificate validity period, such as "66%".
+type renewAtFlag struct {
+	before time.Duration // used if pct is zero
+	pct    float64
+}
+
+func (r *renewAtFlag) String() string {
+	if r.pct > 0 {
+		return strconv.FormatFloat(r.pct, 'f', -1, 64) + "%"
 	}
+	return r.before.String()
 }
 
+func (r *renewAtFlag) Set(v string) error {
+	if strings.HasSuffix(v, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return fmt.Errorf("invalid percentage %q", v)
+		}
+		*r = renewAtFlag{pct: pct}
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*r = renewAtFlag{before: d}
+	return nil
+}
+
+// renewAt returns the time at which crt is due for renewal.
+func (r *renewAtFlag) renewAt(crt *x509.Certificate) time.Time {
+	if r.pct > 0 {
+		life := crt.NotAfter.Sub(crt.NotBefore)
+		return crt.NotBefore.Add(time.Duration(float64(life) * r.pct / 100))
+	}
+	return crt.NotAfter.Add(-r.before)
+}
+
+// certDomains returns normalized names certified by crt,
+// including its subject common name.
+// Names which cannot be normalized are ignored.
+func certDomains(crt *x509.Certificate) []string {
+	names := crt.DNSNames
+	if crt.Subject.CommonName != "" {
+		names = append([]string{crt.Subject.CommonName}, names...)
+	}
+	var res []string
+	for _, n := range names {
+		if d, err := normalizeDomains([]string{n}); err == nil {
+			res = append(res, d...)
+		}
+	}
+	res, _ = normalizeDomains(res)
+	return res
+}
+
+// sameDomains reports whether a and b, both normalized, are equal.
+func sameDomains(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// authz authorizes the client for domain, solving the challenge
+// of the type selected for domain with certChallenge, and then
+// those of certFallback, until one succeeds.
 func authz(ctx context.Context, client *acme.Client, domain string) error {
+	types, err := challengeTypes(domain)
+	if err != nil {
+		return err
+	}
+	for i, typ := range types {
+		if i > 0 {
+			logf("%s: %v; falling back to %s", domain, err, typ)
+		}
+		if err = authzType(ctx, client, domain, typ); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// authzType authorizes the client for domain with a challenge of type typ.
+// A solved challenge is recorded in certUsedChallenges.
+func authzType(ctx context.Context, client *acme.Client, domain, typ string) error {
 	z, err := client.Authorize(ctx, domain)
 	if err != nil {
 		return err
 	}
+	// the CA returns an existing authorization if it has one; only a valid
+	// one needs no challenge, a pending one has to be solved as usual
 	if z.Status == acme.StatusValid {
+		logf("%s: reusing valid authorization %s", domain, z.URI)
 		return nil
 	}
 	var chal *acme.Challenge
 	for _, c := range z.Challenges {
-		if (c.Type == "http-01" && !certDNS) || (c.Type == "dns-01" && certDNS) {
+		if c.Type == typ {
 			chal = c
 			break
 		}
 	}
 	if chal == nil {
-		return errors.New("no supported challenge found")
+		return fmt.Errorf("no %s challenge offered", typ)
 	}
 
-	// respond to http-01 challenge
-	ln, err := net.Listen("tcp", certAddr)
+	var cleanup func()
+	switch typ {
+	case "dns-01":
+		cleanup, err = solveDNS01(ctx, client, domain, chal)
+	default:
+		cleanup, err = solveHTTP01(ctx, client, domain, chal)
+	}
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge: %v", err)
+	}
+	if _, err = client.WaitAuthorization(ctx, z.URI); err != nil {
+		return err
+	}
+	certUsedChallenges[domain] = typ
+	return nil
+}
+
+// solveHTTP01 makes the http-01 challenge response available,
+// either via local server or manually, if certManual is set.
+// The returned func removes the response once the challenge is done.
+func solveHTTP01(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) (func(), error) {
+	val, err := client.HTTP01ChallengeResponse(chal.Token)
 	if err != nil {
-		return fmt.Errorf("listen %s: %v", certAddr, err)
+		return nil, err
+	}
+	path := client.HTTP01ChallengePath(chal.Token)
+
+	if len(certWebroot) > 0 {
+		root, ok := certWebroot.root(domain)
+		if !ok {
+			return nil, fmt.Errorf("no -webroot for %s and no default", domain)
+		}
+		file := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		entry := journalEntry{Type: "file", Domain: domain, Path: file}
+		journalAdd(entry)
+		cleanup := func() {
+			if err := os.Remove(file); err == nil || os.IsNotExist(err) {
+				journalRemove(entry)
+			}
+		}
+		if err := ioutil.WriteFile(file, []byte(val), 0644); err != nil {
+			cleanup()
+			return nil, err
+		}
+		if err := selfCheck(ctx, domain, path, val); err != nil {
+			cleanup()
+			return nil, err
+		}
+		return cleanup, nil
 	}
-	defer ln.Close()
 
-	switch {
-	case certManual:
+	if certManual {
 		// manual challenge response
-		tok, err := client.HTTP01ChallengeResponse(chal.Token)
+		file, err := challengeFile(domain, val)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		file, err := challengeFile(domain, tok)
-		if err != nil {
-			return err
+		entry := journalEntry{Type: "file", Domain: domain, Path: file}
+		journalAdd(entry)
+		cleanup := func() {
+			if err := os.Remove(file); err == nil || os.IsNotExist(err) {
+				journalRemove(entry)
+			}
 		}
-		fmt.Printf("Copy %s to http://%s%s and press enter.\n",
-			file, domain, client.HTTP01ChallengePath(chal.Token))
-		var x string
-		fmt.Scanln(&x)
-	case certDNS:
-		val, err := client.DNS01ChallengeRecord(chal.Token)
-		if err != nil {
-			return err
+		fmt.Fprintf(promptOut, "Copy %s to http://%s%s and press enter.\n", file, domain, path)
+		if err := waitEnter(ctx); err != nil {
+			cleanup()
+			return nil, err
+		}
+		if err := selfCheck(ctx, domain, path, val); err != nil {
+			cleanup()
+			return nil, err
+		}
+		return cleanup, nil
+	}
+
+	// auto, via local server
+	ln, err := net.Listen("tcp", certAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %v", certAddr, err)
+	}
+	srv := &http.Server{Handler: http01Handler(path, val)}
+	go srv.Serve(ln)
+	// cleanup runs after ctx is done too, so it cannot use ctx
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
 		}
-		fmt.Printf("Add a TXT record for _acme-challenge.%s with the value %q and press enter after it has propagated.\n",
+	}
+	if err := selfCheck(ctx, domain, path, val); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return cleanup, nil
+}
+
+// solveDNS01 publishes the dns-01 challenge TXT record, either via acme-dns,
+// if certAcmeDNS is set, or by asking the user to add it.
+// The returned func removes the record, if possible.
+func solveDNS01(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) (func(), error) {
+	val, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+	if certAcmeDNS != nil {
+		a, ok := certAcmeDNS[domain]
+		if !ok {
+			return nil, fmt.Errorf("no acme-dns credentials in %s", certAcmeDNSFile)
+		}
+		if err := a.checkDelegation(ctx, domain); err != nil {
+			return nil, err
+		}
+		if err := a.update(ctx, val); err != nil {
+			return nil, err
+		}
+	} else {
+		// recorded so that acme cleanup can remind to remove it
+		journalAdd(journalEntry{Type: "dns", Domain: domain, Record: "_acme-challenge." + domain, Value: val})
+		fmt.Fprintf(promptOut, "Add a TXT record for _acme-challenge.%s with the value %q and press enter after it has propagated.\n",
 			domain, val)
-		var x string
-		fmt.Scanln(&x)
-	default:
-		// auto, via local server
-		val, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err := waitEnter(ctx); err != nil {
+			logf("remove the TXT record for _acme-challenge.%s", domain)
+			return nil, err
+		}
+	}
+	if err := waitPropagation(ctx, domain, val); err != nil {
+		if certAcmeDNS == nil {
+			logf("remove the TXT record for _acme-challenge.%s", domain)
+		}
+		return nil, err
+	}
+	// acme-dns keeps only the most recent records, and a manually added
+	// record is up to the user
+	return func() {}, nil
+}
+
+// challengeFlag maps domain names to challenge types.
+// The empty key holds the type for domains with no explicit mapping.
+// It is set with values of "type" or "domain=type" form.
+type challengeFlag map[string]string
+
+func (c challengeFlag) String() string {
+	var s []string
+	for d, t := range c {
+		if d == "" {
+			s = append(s, t)
+		} else {
+			s = append(s, d+"="+t)
+		}
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (c challengeFlag) Set(v string) error {
+	var d string
+	t := v
+	if i := strings.Index(v, "="); i >= 0 {
+		dn, err := normalizeDomain(v[:i])
 		if err != nil {
 			return err
 		}
-		path := client.HTTP01ChallengePath(chal.Token)
-		go http.Serve(ln, http01Handler(path, val))
+		d, t = dn, v[i+1:]
+	}
+	switch t {
+	case "http-01", "dns-01":
+	default:
+		return fmt.Errorf("unsupported challenge type %q", t)
+	}
+	if strings.HasPrefix(d, "*.") && t != "dns-01" {
+		return fmt.Errorf("%s: wildcard names require dns-01", d)
+	}
+	c[d] = t
+	return nil
+}
+
+// webrootFlag maps domain names to document roots for http-01 responses.
+// The empty key holds the root for domains with no explicit mapping.
+// It is set with values of "dir", "default=dir" or "domain=dir" form.
+type webrootFlag map[string]string
+
+func (w webrootFlag) String() string {
+	var s []string
+	for d, dir := range w {
+		if d == "" {
+			s = append(s, dir)
+		} else {
+			s = append(s, d+"="+dir)
+		}
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
 
+func (w webrootFlag) Set(v string) error {
+	var d string
+	dir := v
+	if i := strings.Index(v, "="); i >= 0 {
+		d, dir = v[:i], v[i+1:]
+		if d == "default" {
+			d = ""
+		} else {
+			dn, err := normalizeDomain(d)
+			if err != nil {
+				return err
+			}
+			d = dn
+		}
 	}
+	if dir == "" {
+		return fmt.Errorf("empty webroot dir in %q", v)
+	}
+	w[d] = dir
+	return nil
+}
 
-	if _, err := client.Accept(ctx, chal); err != nil {
-		return fmt.Errorf("accept challenge: %v", err)
+// root returns the document root for domain, or the default root.
+func (w webrootFlag) root(domain string) (string, bool) {
+	if dir, ok := w[domain]; ok {
+		return dir, true
+	}
+	dir, ok := w[""]
+	return dir, ok
+}
+
+// curveFlag selects the ECDSA curve of new certificate keys
+// by its bit size. The zero value means the default RSA key.
+type curveFlag int
+
+func (c *curveFlag) String() string {
+	if *c == 0 {
+		return ""
+	}
+	return fmt.Sprintf("P-%d", *c)
+}
+
+func (c *curveFlag) Set(v string) error {
+	switch strings.ToUpper(v) {
+	case "P-256":
+		*c = 256
+	case "P-384":
+		*c = 384
+	case "P-521":
+		*c = 521
+	default:
+		return fmt.Errorf("unsupported curve %q; use P-256, P-384 or P-521", v)
+	}
+	return nil
+}
+
+// certKeySpec returns the spec of new certificate keys,
+// selected with -cert-curve and -encrypt.
+func certKeySpec() keySpec {
+	s := certCurve.keySpec()
+	s.Encrypt = certEncrypt
+	return s
+}
+
+// keySpec returns the spec of keys to generate.
+func (c curveFlag) keySpec() keySpec {
+	if c == 0 {
+		return defaultKeySpec
+	}
+	return keySpec{Type: "ec", Bits: int(c)}
+}
+
+// challengeType returns the challenge type to use for domain,
+// as specified with -challenge or -dns.
+func challengeType(domain string) (string, error) {
+	t, ok := certChallenge[domain]
+	if !ok {
+		t = certChallenge[""]
+	}
+	if t == "" {
+		t = "http-01"
+		if certDNS {
+			t = "dns-01"
+		}
+	}
+	if strings.HasPrefix(domain, "*.") && t != "dns-01" {
+		return "", fmt.Errorf("wildcard names require dns-01, not %s", t)
+	}
+	return t, nil
+}
+
+// challengeTypes returns the challenge types to try for domain in order:
+// the one selected with challengeType, followed by certFallback.
+// With certFallback, the type recorded in certPreferred is tried first.
+// Types which cannot validate domain are skipped.
+func challengeTypes(domain string) ([]string, error) {
+	typ, err := challengeType(domain)
+	if err != nil {
+		return nil, err
+	}
+	types := []string{typ}
+	if len(certFallback) == 0 {
+		return types, nil
+	}
+	if p := certPreferred[domain]; p != "" && (p == typ || certFallback.has(p)) {
+		types = []string{p, typ}
+	}
+	types = append(types, certFallback...)
+	var res []string
+	seen := make(map[string]bool)
+	for _, t := range types {
+		if seen[t] || strings.HasPrefix(domain, "*.") && t != "dns-01" {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// onlyHTTP01 reports whether all of types are http-01.
+func onlyHTTP01(types []string) bool {
+	for _, t := range types {
+		if t != "http-01" {
+			return false
+		}
+	}
+	return true
+}
+
+// challengeListFlag is a comma-separated list of challenge types.
+type challengeListFlag []string
+
+func (l *challengeListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *challengeListFlag) Set(v string) error {
+	var res []string
+	for _, t := range strings.Split(v, ",") {
+		switch t {
+		case "http-01", "dns-01":
+		case "tls-alpn-01":
+			return fmt.Errorf("%s is not supported by the ACME v1 protocol", t)
+		default:
+			return fmt.Errorf("unsupported challenge type %q", t)
+		}
+		res = append(res, t)
+	}
+	*l = res
+	return nil
+}
+
+// has reports whether l contains typ.
+func (l challengeListFlag) has(typ string) bool {
+	for _, t := range l {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// authzDomain runs the authz flow for domain, for at most 10 minutes
+// if only http-01 may be used and it is not solved manually.
+func authzDomain(ctx context.Context, client *acme.Client, domain string) error {
+	var cancel context.CancelFunc
+	if types, _ := challengeTypes(domain); !certManual && onlyHTTP01(types) {
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Minute)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	return authz(ctx, client, domain)
+}
+
+// sameKey reports whether a and b have the same public key.
+// Keys that cannot be compared are reported as the same, so that the
+// shared key check errs on the side of refusing a key.
+func sameKey(a, b crypto.Signer) bool {
+	type equaler interface {
+		Equal(crypto.PublicKey) bool
+	}
+	pa, ok := a.Public().(equaler)
+	if _, okb := b.Public().(equaler); ok && okb {
+		return pa.Equal(b.Public())
+	}
+	da, err := x509.MarshalPKIXPublicKey(a.Public())
+	if err != nil {
+		return true
+	}
+	db, err := x509.MarshalPKIXPublicKey(b.Public())
+	return err != nil || bytes.Equal(da, db)
+}
+
+// selfCheck fetches the http-01 challenge response for domain at path
+// and verifies it matches want, if certSelfCheck is set.
+// The request is sent to certSelfCheckAddr, if specified, instead of
+// the address domain resolves to. It is retried with backoff for up to
+// certSelfCheckAttempts attempts.
+func selfCheck(ctx context.Context, domain, path, want string) error {
+	if !certSelfCheck {
+		return nil
+	}
+	url := "http://" + domain + path
+	target := url
+	if certSelfCheckAddr != "" {
+		target += " via " + certSelfCheckAddr
+	}
+	client := selfCheckClient()
+	wait := certSelfCheckInterval
+	for i := 1; ; i++ {
+		err := fetchChallenge(ctx, client, url, want)
+		if err == nil {
+			return nil
+		}
+		if i >= certSelfCheckAttempts {
+			return fmt.Errorf("self-check of %s failed after %d attempts: %v", target, i, err)
+		}
+		logf("self-check of %s: %v; retrying in %v", target, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("self-check of %s: %v", target, ctx.Err())
+		}
+		wait *= 2
+	}
+}
+
+// selfCheckClient returns the HTTP client for selfCheck requests.
+func selfCheckClient() *http.Client {
+	d := net.Dialer{Resolver: dnsResolver.resolver()}
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if certSelfCheckAddr != "" {
+				addr = certSelfCheckAddr
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	if certSelfCheckProxy {
+		tr.Proxy = clientProxy.proxy()
+	}
+	return &http.Client{Transport: tr}
+}
+
+// fetchChallenge fetches url with client and verifies the response is want.
+func fetchChallenge(ctx context.Context, client *http.Client, url, want string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %s", res.Status)
+	}
+	if strings.TrimSpace(string(b)) != want {
+		return fmt.Errorf("unexpected challenge response %q", b)
+	}
+	return nil
+}
+
+// waitEnter waits for the user to press enter or ctx to be done,
+// whichever happens first.
+func waitEnter(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		var x string
+		fmt.Scanln(&x)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	_, err = client.WaitAuthorization(ctx, z.URI)
-	return err
 }
 
 func challengeFile(domain, content string) (string, error) {
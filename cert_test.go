@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestRenewAtFlag(t *testing.T) {
+	nb := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	crt := &x509.Certificate{NotBefore: nb, NotAfter: nb.Add(90 * 24 * time.Hour)}
+	tests := []struct {
+		v    string
+		want time.Time
+	}{
+		{"720h0m0s", nb.Add(60 * 24 * time.Hour)},
+		{"50%", nb.Add(45 * 24 * time.Hour)},
+		{"100%", crt.NotAfter},
+	}
+	for _, test := range tests {
+		var r renewAtFlag
+		if err := r.Set(test.v); err != nil {
+			t.Errorf("Set(%q): %v", test.v, err)
+			continue
+		}
+		if got := r.renewAt(crt); !got.Equal(test.want) {
+			t.Errorf("%s: renewAt = %v; want %v", test.v, got, test.want)
+		}
+		if r.String() != test.v {
+			t.Errorf("String() = %q; want %q", r.String(), test.v)
+		}
+	}
+	for _, v := range []string{"0%", "101%", "x%", "soon"} {
+		var r renewAtFlag
+		if err := r.Set(v); err == nil {
+			t.Errorf("Set(%q): nil error", v)
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.org"},
+		DNSNames:     []string{"example.org"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "acme-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(r string) { certRoots = r }(certRoots)
+	certRoots = filepath.Join(dir, "roots.pem")
+	if err := writeCert(certRoots, [][]byte{caDER}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyChain([][]byte{leafDER, caDER}, "example.org"); err != nil {
+		t.Errorf("verifyChain: %v", err)
+	}
+	if err := verifyChain([][]byte{leafDER}, "example.com"); err == nil {
+		t.Error("verifyChain with wrong name: nil error")
+	}
+	selfSigned := testCert(t, &x509.Certificate{
+		DNSNames:  []string{"example.org"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour),
+	})
+	if err := verifyChain([][]byte{selfSigned.Raw}, "example.org"); err == nil {
+		t.Error("verifyChain with untrusted root: nil error")
+	}
+
+	if got := trimRoot([][]byte{leafDER, caDER}); len(got) != 1 {
+		t.Errorf("trimRoot(leaf, root): %d certs; want 1", len(got))
+	}
+	if got := trimRoot([][]byte{selfSigned.Raw}); len(got) != 1 {
+		t.Errorf("trimRoot(leaf): %d certs; want 1", len(got))
+	}
+}
+
+func TestChallengeFlag(t *testing.T) {
+	defer func(c challengeFlag, dns bool) { certChallenge, certDNS = c, dns }(certChallenge, certDNS)
+	certChallenge = challengeFlag{}
+	certDNS = false
+	for _, v := range []string{"dns-01", "WWW.example.org=http-01", "*.example.org=dns-01"} {
+		if err := certChallenge.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	tests := []struct{ domain, want string }{
+		{"example.org", "dns-01"},
+		{"www.example.org", "http-01"},
+		{"*.example.org", "dns-01"},
+	}
+	for _, test := range tests {
+		got, err := challengeType(test.domain)
+		if err != nil || got != test.want {
+			t.Errorf("challengeType(%q) = %q, %v; want %q", test.domain, got, err, test.want)
+		}
+	}
+
+	for _, v := range []string{"tls-sni-01", "*.example.org=http-01"} {
+		if err := certChallenge.Set(v); err == nil {
+			t.Errorf("Set(%q): nil error", v)
+		}
+	}
+	certChallenge = challengeFlag{}
+	if _, err := challengeType("*.example.org"); err == nil {
+		t.Error("challengeType of a wildcard with http-01 default: nil error")
+	}
+}
+
+func TestWebrootFlag(t *testing.T) {
+	w := webrootFlag{}
+	if err := w.Set("Example.org=/srv/example"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.root("example.net"); ok {
+		t.Error("root of unmapped domain with no default: ok")
+	}
+	if err := w.Set("default=/var/www"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ domain, want string }{
+		{"example.org", "/srv/example"},
+		{"example.net", "/var/www"},
+	}
+	for _, test := range tests {
+		if got, ok := w.root(test.domain); !ok || got != test.want {
+			t.Errorf("root(%q) = %q, %v; want %q", test.domain, got, ok, test.want)
+		}
+	}
+	if err := w.Set("example.org="); err == nil {
+		t.Error("Set with empty dir: nil error")
+	}
+}
+
+func TestEKUFlag(t *testing.T) {
+	var e ekuFlag
+	if err := e.Set("both"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Set("code-signing"); err == nil {
+		t.Error("Set(code-signing): nil error")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exts, err := e.extensions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames:        []string{"example.org"},
+		ExtraExtensions: exts,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, ext := range csr.Extensions {
+		found = found || ext.Id.Equal(oidExtKeyUsage)
+	}
+	if !found {
+		t.Error("CSR has no extended key usage extension")
+	}
+
+	crt := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if err := e.check(crt); err == nil {
+		t.Error("check of server-only cert: nil error")
+	}
+	crt.ExtKeyUsage = append(crt.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	if err := e.check(crt); err != nil {
+		t.Errorf("check: %v", err)
+	}
+}
+
+// newIssuingCA returns a fake ACME v1 CA with valid authorizations
+// for any domain, which issues certificates for any CSR, signed with key.
+// The CSRs received are sent to csrs.
+func newIssuingCA(t *testing.T, key *ecdsa.PrivateKey, csrs chan<- *x509.CertificateRequest) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch {
+		case r.Method == "HEAD":
+		case r.URL.Path == "/directory":
+			fmt.Fprintf(w, `{"new-authz": %q, "new-cert": %q}`, ts.URL+"/new-authz", ts.URL+"/new-cert")
+		case r.URL.Path == "/new-authz":
+			w.Header().Set("Location", ts.URL+"/authz")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"status": "valid"}`)
+		case r.URL.Path == "/new-cert":
+			var jws struct{ Payload string }
+			var req struct{ CSR string }
+			b, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				err = json.Unmarshal(b, &jws)
+			}
+			if err == nil {
+				b, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+			}
+			if err == nil {
+				err = json.Unmarshal(b, &req)
+			}
+			if err == nil {
+				b, err = base64.RawURLEncoding.DecodeString(req.CSR)
+			}
+			var csr *x509.CertificateRequest
+			if err == nil {
+				csr, err = x509.ParseCertificateRequest(b)
+			}
+			var der []byte
+			if err == nil {
+				csrs <- csr
+				tmpl := &x509.Certificate{
+					SerialNumber: big.NewInt(1),
+					DNSNames:     csr.DNSNames,
+					NotBefore:    time.Now().Add(-time.Hour),
+					NotAfter:     time.Now().Add(time.Hour),
+				}
+				der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, csr.PublicKey, key)
+			}
+			if err != nil {
+				t.Errorf("new-cert: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Location", ts.URL+"/cert/1")
+			w.WriteHeader(http.StatusCreated)
+			w.Write(der)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestIssueCertCurve(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrs := make(chan *x509.CertificateRequest, 1)
+	ca := newIssuingCA(t, caKey, csrs)
+	defer ca.Close()
+	dir, err := ioutil.TempDir("", "acme-curve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c curveFlag, b bool) { certCurve, certBundle = c, b }(certCurve, certBundle)
+	certBundle = false
+	if err := certCurve.Set("P-384"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "example.org.key")
+	key, err := anyKey(path, true, certCurve.keySpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: caKey, DirectoryURL: ca.URL + "/directory"}
+	cert, err := issueCert(context.Background(), client, "", []string{"example.org"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csr := <-csrs; csr.SignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("CSR signature algorithm = %v; want %v", csr.SignatureAlgorithm, x509.ECDSAWithSHA384)
+	}
+	crtPath := filepath.Join(dir, "example.org.crt")
+	if err := writeCert(crtPath, cert); err != nil {
+		t.Fatal(err)
+	}
+	crt, err := readCrt(crtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, ok := crt.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P384() {
+		t.Errorf("cert public key is %T, not on P-384", crt.PublicKey)
+	}
+	read, err := readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(read, key) {
+		t.Error("read key differs from the generated one")
+	}
+
+	if err := certCurve.Set("P-224"); err == nil {
+		t.Error("Set(P-224): nil error")
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-issuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { certIssuer = s }(certIssuer)
+	certIssuer = ""
+	chain := func(cn ...string) [][]byte {
+		var res [][]byte
+		for _, n := range cn {
+			res = append(res, testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: n}}).Raw)
+		}
+		return res
+	}
+	path := filepath.Join(dir, "example.org.crt")
+
+	if err := checkIssuer(path, chain("R3", "Root X1")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Issuer != "Root X1" {
+		t.Errorf("recorded issuer %q; want %q", m.Issuer, "Root X1")
+	}
+	// A different root is only a warning without -expect-issuer.
+	if err := checkIssuer(path, chain("E1", "Root X2")); err != nil {
+		t.Errorf("changed root: %v", err)
+	}
+	if m, _ := readMeta(path); m.Issuer != "Root X1" {
+		t.Errorf("recorded issuer changed to %q", m.Issuer)
+	}
+
+	certIssuer = "R3"
+	if err := checkIssuer(path, chain("R3", "Root X1")); err != nil {
+		t.Errorf("expected intermediate: %v", err)
+	}
+	if err := checkIssuer(path, chain("R10", "Root X1")); err == nil {
+		t.Error("unexpected issuer: nil error")
+	}
+}
+
+func TestSelfCheckRetry(t *testing.T) {
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n++; n < 3 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "token.thumb")
+	}))
+	defer ts.Close()
+	defer func(check bool, addr string, a int, d time.Duration) {
+		certSelfCheck, certSelfCheckAddr, certSelfCheckAttempts, certSelfCheckInterval = check, addr, a, d
+	}(certSelfCheck, certSelfCheckAddr, certSelfCheckAttempts, certSelfCheckInterval)
+	certSelfCheck = true
+	certSelfCheckAddr = ts.Listener.Addr().String()
+	certSelfCheckInterval = time.Millisecond
+
+	certSelfCheckAttempts = 3
+	if err := selfCheck(context.Background(), "example.org", "/path", "token.thumb"); err != nil {
+		t.Errorf("third attempt: %v", err)
+	}
+	n = 0
+	certSelfCheckAttempts = 2
+	err := selfCheck(context.Background(), "example.org", "/path", "token.thumb")
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), certSelfCheckAddr) {
+		t.Errorf("two attempts: error %v; want 404 via %s", err, certSelfCheckAddr)
+	}
+}
+
+func TestExpiryWarning(t *testing.T) {
+	nb := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	crt := &x509.Certificate{NotBefore: nb, NotAfter: nb.Add(7 * 24 * time.Hour)}
+	if s := expiryWarning(crt, crt.NotAfter.Add(-time.Minute)); s != "" {
+		t.Errorf("honored expiry: %q", s)
+	}
+	if s := expiryWarning(crt, nb.Add(90*24*time.Hour)); !strings.Contains(s, "7 days") {
+		t.Errorf("ignored expiry: %q; want 7 days", s)
+	}
+}
+
+func TestChallengeTypes(t *testing.T) {
+	defer func(c challengeFlag, f challengeListFlag, p map[string]string) {
+		certChallenge, certFallback, certPreferred = c, f, p
+	}(certChallenge, certFallback, certPreferred)
+	certChallenge = challengeFlag{}
+	certFallback = nil
+	certPreferred = map[string]string{"example.org": "dns-01", "example.net": "dns-01"}
+
+	check := func(domain string, want ...string) {
+		t.Helper()
+		got, err := challengeTypes(domain)
+		if err != nil {
+			t.Errorf("%s: %v", domain, err)
+			return
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: %q; want %q", domain, got, want)
+		}
+	}
+	check("example.org", "http-01")
+	if err := certFallback.Set("dns-01"); err != nil {
+		t.Fatal(err)
+	}
+	check("example.org", "dns-01", "http-01")
+	check("example.com", "http-01", "dns-01")
+	if err := certChallenge.Set("*.example.org=dns-01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := certFallback.Set("http-01"); err != nil {
+		t.Fatal(err)
+	}
+	check("*.example.org", "dns-01")
+	// the recorded type is no longer allowed
+	check("example.net", "http-01")
+
+	if err := certFallback.Set("tls-alpn-01"); err == nil {
+		t.Error("Set(tls-alpn-01): nil error")
+	}
+}
+
+// opaqueSigner is a signer whose public key cannot be compared.
+type opaqueSigner struct{ crypto.Signer }
+
+func (opaqueSigner) Public() crypto.PublicKey { return struct{}{} }
+
+func TestSameKey(t *testing.T) {
+	a, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(a, a) || sameKey(a, b) {
+		t.Error("sameKey does not compare ECDSA keys")
+	}
+	if !sameKey(opaqueSigner{a}, b) || !sameKey(b, opaqueSigner{a}) {
+		t.Error("keys that cannot be compared are reported as different")
+	}
+}
+
+func TestLockCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a lock file left behind by a killed process
+	certPath := filepath.Join(dir, "example.org.crt")
+	if err := ioutil.WriteFile(lockPath(certPath), []byte("pid 1 since 2017-01-01T00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lockCert(certPath); err != nil {
+		t.Fatalf("lockCert with a leftover lock file: %v", err)
+	}
+	b, err := ioutil.ReadFile(lockPath(certPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("pid %d ", os.Getpid()); !strings.HasPrefix(string(b), want) {
+		t.Errorf("lock file = %q; want prefix %q", b, want)
+	}
+	if !fileLocking {
+		t.Skip("no file locking on this system")
+	}
+	if err := lockCert(certPath); err == nil {
+		t.Error("lockCert of a locked cert: nil error")
+	}
+	if err := lockCert(filepath.Join(dir, "other.crt")); err != nil {
+		t.Errorf("lockCert of another cert: %v", err)
+	}
+}
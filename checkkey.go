@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"time"
+)
+
+// configCheckKey enables checkAccountKey in readConfig.
+// It is set with -check-key flag, common to all subcommands.
+var configCheckKey bool
+
+// checkAccountKey warns about properties of the uc account key
+// which CAs commonly reject, and then looks up the account with it,
+// so that an unacceptable key is reported before a longer operation fails.
+// The check is best-effort: ACME directories do not advertise
+// accepted key types, so problems are only logged.
+func checkAccountKey(uc *userConfig) {
+	if err := keyPolicy(uc.key); err != nil {
+		logf("warning: account key %s: %v", uc.keyPath(), err)
+	}
+	if uc.URI == "" || uc.CA == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := newClient(uc.key, uc.CA).GetReg(ctx, uc.URI); err != nil {
+		logf("warning: account lookup with key %s failed: %v", uc.keyPath(), err)
+	}
+}
+
+// keyPolicy returns an error if k is not an RSA key of 2048 to 4096 bits
+// or an ECDSA key on P-256 or P-384, which is what CAs generally accept.
+func keyPolicy(k crypto.Signer) error {
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		if n := k.N.BitLen(); n < 2048 || n > 4096 {
+			return fmt.Errorf("RSA key size %d is outside of the commonly accepted 2048-4096 bits", n)
+		}
+	case *ecdsa.PrivateKey:
+		switch n := k.Curve.Params().BitSize; n {
+		case 256, 384:
+		default:
+			return fmt.Errorf("EC curve P-%d is not commonly accepted; use P-256 or P-384", n)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", k)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var (
+	cmdCleanup = &command{
+		run:       runCleanup,
+		UsageLine: "cleanup [-c config] [-n]",
+		Short:     "remove leftover challenge artifacts",
+		Long: `
+Cleanup removes challenge artifacts left behind by interrupted cert
+and rekey runs. The artifacts are recorded in {{.JournalFile}}
+in the config dir while they exist.
+
+Challenge response files are removed. Manually added dns-01 TXT records
+cannot be removed by the program, so the records to remove are printed.
+The journal is then cleared.
+
+The -n flag only prints what would be done.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	cleanupDryRun bool
+)
+
+func init() {
+	cmdCleanup.flag.BoolVar(&cleanupDryRun, "n", cleanupDryRun, "")
+}
+
+func runCleanup(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	list, err := readJournal()
+	if err != nil {
+		fatalf("challenge journal: %v", err)
+	}
+	var keep []journalEntry
+	for _, e := range list {
+		switch e.Type {
+		case "file":
+			if cleanupDryRun {
+				fmt.Printf("would remove %s (%s)\n", e.Path, e.Domain)
+				continue
+			}
+			if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+				errorf("%v", err)
+				keep = append(keep, e)
+				continue
+			}
+			fmt.Printf("removed %s (%s)\n", e.Path, e.Domain)
+		case "dns":
+			fmt.Printf("remove the TXT record %s with the value %q\n", e.Record, e.Value)
+		default:
+			logf("unknown journal entry type %q", e.Type)
+		}
+	}
+	if cleanupDryRun {
+		return
+	}
+	if err := writeJournal(keep); err != nil {
+		fatalf("challenge journal: %v", err)
+	}
+}
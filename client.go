@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// userAgent is the User-Agent header value sent with every request
+// to a CA. It may be modified using -user-agent flag, common to all subcommands.
+var userAgent = "acme"
+
+// clientProxy is the proxy used for requests to a CA and acme-dns servers.
+// If unset, the proxy is taken from HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables.
+// It may be set using -proxy flag, common to all subcommands.
+var clientProxy proxyFlag
+
+// clientCACert holds additional roots trusted for TLS connections
+// to a CA and acme-dns servers, such as that of a local test CA.
+// It may be set using -ca-cert flag, common to all subcommands.
+var clientCACert caCertFlag
+
+// clientHTTPTimeout limits each stage of a single HTTP request to a CA
+// and acme-dns servers: dialing, the TLS handshake and waiting for
+// the response header. Zero means no limit. It is separate from the
+// overall deadline of a command, so that a hung connection fails promptly.
+// It may be set using -http-timeout flag, common to all subcommands.
+var clientHTTPTimeout = 30 * time.Second
+
+// clientPrintJWS makes ACME clients print the JWS requests they send.
+// It is set using -print-jws flag of the reg, cert and rekey commands.
+var clientPrintJWS bool
+
+// newClient returns an ACME client signing requests with key.
+// The dirURL is the CA directory endpoint; it may be empty when
+// the client is used only to access account resources.
+func newClient(key crypto.Signer, dirURL string) *acme.Client {
+	tr := newTransport()
+	if clientPrintJWS {
+		tr = &jwsTransport{w: os.Stderr, base: tr}
+	}
+	return &acme.Client{
+		Key:          key,
+		DirectoryURL: dirURL,
+		HTTPClient:   &http.Client{Transport: tr},
+	}
+}
+
+// newTransport returns the HTTP transport used by ACME clients.
+func newTransport() http.RoundTripper {
+	// same as http.DefaultTransport, except for the proxy, roots and timeouts
+	base := &http.Transport{
+		Proxy:           clientProxy.proxy(),
+		TLSClientConfig: &tls.Config{RootCAs: clientCACert.pool},
+		DialContext: (&net.Dialer{
+			Timeout:   clientHTTPTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   clientHTTPTimeout,
+		ResponseHeaderTimeout: clientHTTPTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &uaTransport{
+		ua:   userAgent,
+		base: base,
+	}
+}
+
+// proxyFlag is a flag holding a proxy URL.
+type proxyFlag struct {
+	u *url.URL
+}
+
+func (p *proxyFlag) String() string {
+	if p.u == nil {
+		return ""
+	}
+	return p.u.String()
+}
+
+func (p *proxyFlag) Set(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy URL %q has no host", v)
+	}
+	p.u = u
+	return nil
+}
+
+// caCertFlag is a flag naming a PEM file of root certificates,
+// trusted in addition to the system roots.
+type caCertFlag struct {
+	path string
+	pool *x509.CertPool // nil until set, selecting the system roots
+}
+
+func (c *caCertFlag) String() string {
+	return c.path
+}
+
+func (c *caCertFlag) Set(v string) error {
+	crts, err := readCerts(v)
+	if err != nil {
+		return err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, crt := range crts {
+		pool.AddCert(crt)
+	}
+	c.path, c.pool = v, pool
+	return nil
+}
+
+// proxy returns the proxy func for an http.Transport:
+// p itself, if set, or the environment configured proxy.
+func (p *proxyFlag) proxy() func(*http.Request) (*url.URL, error) {
+	if p.u != nil {
+		return http.ProxyURL(p.u)
+	}
+	return http.ProxyFromEnvironment
+}
+
+// uaTransport sets the User-Agent header on all requests
+// passing through it.
+type uaTransport struct {
+	ua   string
+	base http.RoundTripper
+}
+
+func (t *uaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("User-Agent", t.ua)
+	return t.base.RoundTrip(r)
+}
+
+// jwsTransport prints the protected header, payload and signature
+// of JWS request bodies passing through it to w, with the header and
+// payload decoded. The JWS contains no private key material.
+type jwsTransport struct {
+	w    io.Writer
+	base http.RoundTripper
+}
+
+func (t *jwsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Method != "POST" {
+		return t.base.RoundTrip(req)
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	printJWS(t.w, req.URL.String(), b)
+	r := new(http.Request)
+	*r = *req
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return t.base.RoundTrip(r)
+}
+
+// printJWS writes the flattened JSON JWS in b, sent to url, into w.
+// Bodies which are not a JWS are skipped.
+func printJWS(w io.Writer, url string, b []byte) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if json.Unmarshal(b, &jws) != nil || jws.Protected == "" {
+		return
+	}
+	decode := func(s string) string {
+		d, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Sprintf("%s (invalid base64url: %v)", s, err)
+		}
+		return string(d)
+	}
+	fmt.Fprintf(w, "JWS POST %s\n", url)
+	fmt.Fprintf(w, "protected: %s\n", decode(jws.Protected))
+	fmt.Fprintf(w, "payload: %s\n", decode(jws.Payload))
+	fmt.Fprintf(w, "signature: %s\n", jws.Signature)
+}
+
+// fetch returns the document served at url, which must not exceed max bytes.
+// It is a plain GET request, for documents such as the CA directory.
+func fetch(ctx context.Context, url string, max int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: newTransport()}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, res.Status)
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%s: response exceeds %d bytes", url, max)
+	}
+	return b, nil
+}
+
+// postJWS sends body to url as a JWS signed with the key of client,
+// the same way the acme package does. It is used for requests the package
+// cannot express, such as an empty contact list, which it omits.
+// A CA error response is returned as *acme.Error.
+//
+// A nonce rejected as stale, as a load balanced CA may do, says nothing
+// of the request itself, so it is sent once more with a fresh nonce.
+func postJWS(ctx context.Context, client *acme.Client, url string, body interface{}) error {
+	hc := client.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	err := postJWSNonce(ctx, hc, client.Key, url, body)
+	if e, ok := err.(*acme.Error); ok && e.ProblemType == "urn:acme:error:badNonce" {
+		err = postJWSNonce(ctx, hc, client.Key, url, body)
+	}
+	return err
+}
+
+// postJWSNonce fetches a nonce from url and sends body signed with key
+// and the nonce to url, as postJWS.
+func postJWSNonce(ctx context.Context, hc *http.Client, key crypto.Signer, url string, body interface{}) error {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	nonce := res.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return fmt.Errorf("%s: no nonce in response", url)
+	}
+	b, err := signJWS(key, nonce, body)
+	if err != nil {
+		return err
+	}
+	req, err = http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	res, err = hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	var p struct {
+		Type   string `json:"type"`
+		Detail string `json:"detail"`
+	}
+	b, _ = ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if json.Unmarshal(b, &p) != nil {
+		p.Detail = string(b)
+	}
+	return &acme.Error{
+		StatusCode:  res.StatusCode,
+		ProblemType: p.Type,
+		Detail:      p.Detail,
+		Header:      res.Header,
+	}
+}
+
+// signJWS returns the flattened JSON JWS of body, signed with key
+// and carrying its public JWK and nonce in the protected header.
+func signJWS(key crypto.Signer, nonce string, body interface{}) ([]byte, error) {
+	k, err := privateJWK(key, "")
+	if err != nil {
+		return nil, err
+	}
+	// the public members only, sorted as the acme package sends them
+	jwk := map[string]string{"kty": k.Kty}
+	if k.Kty == "RSA" {
+		jwk["n"], jwk["e"] = k.N, k.E
+	} else {
+		jwk["crv"], jwk["x"], jwk["y"] = k.Crv, k.X, k.Y
+	}
+	phead, err := json.Marshal(struct {
+		Alg   string            `json:"alg"`
+		JWK   map[string]string `json:"jwk"`
+		Nonce string            `json:"nonce"`
+	}{k.Alg, jwk, nonce})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	signed := b64(phead) + "." + b64(payload)
+	hash := crypto.SHA256
+	switch k.Alg {
+	case "ES384":
+		hash = crypto.SHA384
+	case "ES512":
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	sig, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		// JWS uses the fixed size r||s form, not ASN.1
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return nil, err
+		}
+		n := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(rs.R.FillBytes(make([]byte, n)), rs.S.FillBytes(make([]byte, n))...)
+	}
+	return json.Marshal(struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{b64(phead), b64(payload), b64(sig)})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestClientUserAgent(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"new-reg": "https://example.com/acme/new-reg"}`)
+	}))
+	defer ts.Close()
+
+	defer func(ua string) { userAgent = ua }(userAgent)
+	userAgent = "acme-test/1.0"
+	client := newClient(nil, ts.URL)
+	if _, err := client.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != userAgent {
+		t.Errorf("User-Agent = %q; want [%q]", got, userAgent)
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var got []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.String())
+		fmt.Fprint(w, `{"new-reg": "https://example.com/acme/new-reg"}`)
+	}))
+	defer proxy.Close()
+
+	defer func(p proxyFlag) { clientProxy = p }(clientProxy)
+	if err := clientProxy.Set(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	const disco = "http://ca.example.com/directory"
+	client := newClient(nil, disco)
+	if _, err := client.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != disco {
+		t.Errorf("proxied requests = %q; want [%q]", got, disco)
+	}
+
+	for _, v := range []string{"ftp://proxy", "http://"} {
+		var p proxyFlag
+		if err := p.Set(v); err == nil {
+			t.Errorf("Set(%q): nil error", v)
+		}
+	}
+}
+
+func TestPrintJWS(t *testing.T) {
+	ca := newTestCA("jws")
+	defer ca.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: ca.URL + "/directory",
+		HTTPClient:   &http.Client{Transport: &jwsTransport{w: &buf, base: http.DefaultTransport}},
+	}
+	a := &acme.Account{Contact: []string{"mailto:admin@example.org"}}
+	if _, err := client.Register(context.Background(), a, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"JWS POST " + ca.URL + "/new-reg\n",
+		`protected: {"alg":"ES256"`,
+		`payload: {"resource":"new-reg","contact":["mailto:admin@example.org"]}`,
+		"signature: ",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output does not contain %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, `"d":`) {
+		t.Errorf("output contains a private key:\n%s", out)
+	}
+}
+
+func TestClientCACert(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"new-reg": "https://example.com/acme/new-reg"}`)
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "acme-cacert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "root.pem")
+	if err := writeCert(path, [][]byte{ts.Certificate().Raw}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(c caCertFlag) { clientCACert = c }(clientCACert)
+	clientCACert = caCertFlag{}
+	if _, err := newClient(nil, ts.URL).Discover(context.Background()); err == nil {
+		t.Error("Discover without -ca-cert: nil error")
+	}
+	if err := clientCACert.Set(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newClient(nil, ts.URL).Discover(context.Background()); err != nil {
+		t.Errorf("Discover with -ca-cert: %v", err)
+	}
+	if err := (&caCertFlag{}).Set(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Set(missing file): nil error")
+	}
+}
+
+func TestClientHTTPTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	defer func(d time.Duration) { clientHTTPTimeout = d }(clientHTTPTimeout)
+	clientHTTPTimeout = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := newClient(nil, ts.URL).Discover(ctx); err == nil {
+		t.Fatal("Discover from a hung server: nil error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Discover took %v; want about %v", d, clientHTTPTimeout)
+	}
+}
+
+func TestPostJWSBadNonce(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CA rejects the nonce of the first reject requests;
+	// a single retry is made, with a fresh nonce
+	for _, reject := range []int{1, 2} {
+		var issued int
+		var used []string
+		ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			issued++
+			w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce%d", issued))
+			if r.Method == "HEAD" {
+				return
+			}
+			var jws struct{ Protected string }
+			json.NewDecoder(r.Body).Decode(&jws)
+			b, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+			var h struct{ Nonce string }
+			json.Unmarshal(b, &h)
+			used = append(used, h.Nonce)
+			if len(used) <= reject {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"type": "urn:acme:error:badNonce", "detail": "JWS has an invalid anti-replay nonce"}`)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{}`)
+		}))
+		err := postJWS(context.Background(), newClient(key, ""), ca.URL+"/reg/1", map[string]string{"resource": "reg"})
+		ca.Close()
+		if reject == 1 && err != nil {
+			t.Errorf("postJWS after one bad nonce: %v", err)
+		}
+		if e, ok := err.(*acme.Error); reject == 2 && (!ok || e.ProblemType != "urn:acme:error:badNonce") {
+			t.Errorf("postJWS after two bad nonces: err = %v; want badNonce", err)
+		}
+		if len(used) != 2 || used[0] == used[1] {
+			t.Errorf("reject %d: nonces sent %q; want two fresh ones", reject, used)
+		}
+	}
+}
@@ -12,12 +12,16 @@
 package main
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,6 +30,7 @@ import (
 	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"golang.org/x/crypto/acme"
 )
@@ -36,18 +41,49 @@ const (
 	// accountKey is the default user account private key file.
 	accountKey = "account.key"
 
-	rsaPrivateKey = "RSA PRIVATE KEY"
-	ecPrivateKey  = "EC PRIVATE KEY"
-	x509PublicKey = "CERTIFICATE"
+	rsaPrivateKey   = "RSA PRIVATE KEY"
+	ecPrivateKey    = "EC PRIVATE KEY"
+	pkcs8PrivateKey = "PRIVATE KEY"
+	x509PublicKey   = "CERTIFICATE"
 )
 
 // configDir is acme configuration dir.
-// It may be empty string.
+// It may be empty string, in which case commands working with files
+// in it fail with errNoConfigDir, see checkConfigDir.
 //
 // The value is initialized at startup and is also allowed to be modified
 // using -c flag, common to all subcommands.
 var configDir string
 
+// configCA is the discovery URL of the CA whose account is used
+// by readConfig. An empty value selects the default account.
+//
+// The value may be set using -ca flag, common to all subcommands.
+var configCA discoAliasFlag
+
+// configFile and configKeyFile are the names of the user config file
+// and the default account key file in configDir.
+//
+// The values may be changed using -account-file and -account-key-file
+// flags, common to all subcommands, independently of each other.
+var (
+	configFile    = accountFile
+	configKeyFile = accountKey
+)
+
+// errNoConfigDir is returned by checkConfigDir when configDir is unset.
+var errNoConfigDir = errors.New("could not determine config dir; set ACME_CONFIG or pass -c")
+
+// checkConfigDir reports an error if configDir is empty,
+// so that no files are read from or written to the current directory
+// by accident.
+func checkConfigDir() error {
+	if configDir == "" {
+		return errNoConfigDir
+	}
+	return nil
+}
+
 func init() {
 	configDir = os.Getenv("ACME_CONFIG")
 	if configDir != "" {
@@ -63,16 +99,110 @@ type userConfig struct {
 	acme.Account
 	CA string `json:"ca"` // CA discovery URL
 
+	// Key is the account key file name, relative to configDir,
+	// or an absolute path for a key stored elsewhere.
+	// Empty value means configKeyFile.
+	Key string `json:"key,omitempty"`
+
+	// TermsAcceptedAt is when the CA terms recorded in AgreedTerms
+	// were accepted during registration, for audit purposes.
+	TermsAcceptedAt *time.Time `json:"termsAcceptedAt,omitempty"`
+	// TermsHash is the hex encoded SHA-256 of the terms document
+	// fetched when it was accepted. See termsHash.
+	TermsHash string `json:"termsHash,omitempty"`
+
+	// Defaults are the stored defaults of the cert and rekey commands,
+	// set with the defaults command.
+	Defaults *certDefaults `json:"defaults,omitempty"`
+
+	// Accounts are accounts at CAs other than CA, keyed by CA discovery URL.
+	// Only the default account, stored at the top level of the config file,
+	// has this field set.
+	Accounts map[string]*userConfig `json:"accounts,omitempty"`
+
 	// key is stored separately
 	key crypto.Signer
 }
 
-// readConfig reads userConfig from path and a private key.
-// It expects to find the key at the same location,
-// by replacing path extention with ".key".
-//func readConfig(name string) (*userConfig, error) {
+// keyPath returns the location of the account key file.
+func (uc *userConfig) keyPath() string {
+	if filepath.IsAbs(uc.Key) {
+		return uc.Key
+	}
+	if uc.Key != "" {
+		return filepath.Join(configDir, uc.Key)
+	}
+	return filepath.Join(configDir, configKeyFile)
+}
+
+// configKeyName returns the value of userConfig.Key for the key file at path:
+// the name relative to configDir if the file is in it, or the absolute path.
+func configKeyName(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(configDir)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel, nil
+	}
+	return abs, nil
+}
+
+// readConfig reads userConfig of the account selected with configCA
+// and its private key, checking the key if configCheckKey is set.
+// It expects to find the key in configDir, see userConfig.keyPath.
 func readConfig() (*userConfig, error) {
-	b, err := ioutil.ReadFile(filepath.Join(configDir, accountFile))
+	uc, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if ca := string(configCA); ca != "" && ca != uc.CA {
+		a, ok := uc.Accounts[ca]
+		if !ok {
+			return nil, fmt.Errorf("no account for CA %s", ca)
+		}
+		a.CA = ca
+		uc = a
+	}
+	if key, err := readKey(uc.keyPath()); err == nil {
+		uc.key = key
+		if configCheckKey {
+			checkAccountKey(uc)
+		}
+	}
+	return uc, nil
+}
+
+// storedAccount returns the account at the CA with discovery URL ca
+// stored in the config file, without its other accounts,
+// or nil if there is none.
+func storedAccount(ca string) *userConfig {
+	cur, err := readConfigFile()
+	if err != nil {
+		return nil
+	}
+	if cur.CA == ca && cur.URI != "" {
+		cur.Accounts = nil
+		return cur
+	}
+	if a, ok := cur.Accounts[ca]; ok {
+		a.CA = ca
+		return a
+	}
+	return nil
+}
+
+// readConfigFile reads the whole config file, including all accounts.
+// Keys are not read.
+func readConfigFile() (*userConfig, error) {
+	if err := checkConfigDir(); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(configDir, configFile))
 	if err != nil {
 		return nil, err
 	}
@@ -80,17 +210,34 @@ func readConfig() (*userConfig, error) {
 	if err := json.Unmarshal(b, uc); err != nil {
 		return nil, err
 	}
-	if key, err := readKey(filepath.Join(configDir, accountKey)); err == nil {
-		uc.key = key
-	}
 	return uc, nil
 }
 
-// writeConfig writes uc to a file specified by path, creating paret dirs
+// writeConfig writes uc to the config file in configDir, creating parent dirs
 // along the way. If file does not exists, it will be created with 0600 mod.
 // This function does not store uc.key.
-//func writeConfig(path string, uc *userConfig) error {
+//
+// If the file already exists with a default account at a CA other than uc.CA,
+// uc is stored in its Accounts, leaving other accounts intact.
 func writeConfig(uc *userConfig) error {
+	if err := checkConfigDir(); err != nil {
+		return err
+	}
+	if cur, err := readConfigFile(); err == nil {
+		if cur.URI != "" && cur.CA != "" && cur.CA != uc.CA {
+			a := *uc
+			a.Accounts = nil
+			if cur.Accounts == nil {
+				cur.Accounts = make(map[string]*userConfig)
+			}
+			cur.Accounts[uc.CA] = &a
+			uc = cur
+		} else if uc.Accounts == nil && cur.Accounts != nil {
+			a := *uc
+			a.Accounts = cur.Accounts
+			uc = &a
+		}
+	}
 	b, err := json.MarshalIndent(uc, "", "  ")
 	if err != nil {
 		return err
@@ -98,54 +245,199 @@ func writeConfig(uc *userConfig) error {
 	if err := os.MkdirAll(configDir, 0700); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filepath.Join(configDir, accountFile), b, 0600)
+	return ioutil.WriteFile(filepath.Join(configDir, configFile), b, 0600)
 }
 
 // readKey reads a private RSA or EC key from path.
-// The key is expected to be in PEM format.
+// The key is expected to be in PEM format, either PKCS#1, SEC 1 or PKCS#8 encoded.
+// Blocks of other types, such as parameters or explanatory text, preceding
+// the key are skipped. If there is no block of a known key type, blocks
+// with unrecognized types are tried with all the key parsers.
 func readKey(path string) (crypto.Signer, error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	d, _ := pem.Decode(b)
-	if d == nil {
-		return nil, fmt.Errorf("no block found in %q", path)
+	var other []*pem.Block
+	for {
+		var d *pem.Block
+		d, b = pem.Decode(b)
+		if d == nil {
+			break
+		}
+		if isEncryptedBlock(d) {
+			if d, err = decryptKey(path, d); err != nil {
+				return nil, err
+			}
+		}
+		switch d.Type {
+		case rsaPrivateKey, ecPrivateKey, pkcs8PrivateKey:
+			return parseKey(d)
+		}
+		other = append(other, d)
+	}
+	if len(other) == 0 {
+		return nil, fmt.Errorf("no PEM block found in %q", path)
+	}
+	var types []string
+	for _, d := range other {
+		if k, err := parseKey(d); err == nil {
+			return k, nil
+		}
+		types = append(types, fmt.Sprintf("%q", d.Type))
+	}
+	return nil, fmt.Errorf("%q: no key found in %s blocks; tried PKCS#8, PKCS#1 and EC parsers",
+		path, strings.Join(types, ", "))
+}
+
+// decryptKey decrypts the encrypted PEM block d read from path,
+// using the keyPassEnv passphrase. The block is either a PKCS#8
+// ENCRYPTED PRIVATE KEY, or a legacy RFC 1423 encrypted block
+// written by earlier versions, which is still read so that
+// the passwd command can convert it.
+func decryptKey(path string, d *pem.Block) (*pem.Block, error) {
+	pass := os.Getenv(keyPassEnv)
+	if pass == "" {
+		return nil, fmt.Errorf("%s is encrypted; set %s to its passphrase", path, keyPassEnv)
+	}
+	if d.Type == encryptedPrivateKey {
+		b, err := decryptPKCS8(d.Bytes, []byte(pass))
+		if err == errPassphrase {
+			return nil, fmt.Errorf("%s: wrong passphrase in %s", path, keyPassEnv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return &pem.Block{Type: pkcs8PrivateKey, Bytes: b}, nil
+	}
+	b, err := x509.DecryptPEMBlock(d, []byte(pass))
+	if err == x509.IncorrectPasswordError {
+		return nil, fmt.Errorf("%s: wrong passphrase in %s", path, keyPassEnv)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &pem.Block{Type: d.Type, Bytes: b}, nil
+}
+
+// parseKey parses the private key in d, trying the parser matching
+// d.Type first, and then the others, in case the block is mislabeled.
+func parseKey(d *pem.Block) (crypto.Signer, error) {
+	parsers := []func([]byte) (crypto.Signer, error){parsePKCS8, parsePKCS1, parseEC}
 	switch d.Type {
 	case rsaPrivateKey:
-		return x509.ParsePKCS1PrivateKey(d.Bytes)
+		parsers[0], parsers[1] = parsers[1], parsers[0]
 	case ecPrivateKey:
-		return x509.ParseECPrivateKey(d.Bytes)
-	default:
-		return nil, fmt.Errorf("%q is unsupported", d.Type)
+		parsers[0], parsers[2] = parsers[2], parsers[0]
 	}
+	var err error
+	for _, p := range parsers {
+		var k crypto.Signer
+		if k, err = p(d.Bytes); err == nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is unsupported: %v", d.Type, err)
 }
 
+func parsePKCS8(der []byte) (crypto.Signer, error) {
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+	return s, nil
+}
+
+func parsePKCS1(der []byte) (crypto.Signer, error) {
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+func parseEC(der []byte) (crypto.Signer, error) {
+	return x509.ParseECPrivateKey(der)
+}
+
+// readCrt reads the first x509 certificate found in the PEM file at path.
 func readCrt(path string) (*x509.Certificate, error) {
+	d, err := readBlock(path, x509PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(d.Bytes)
+}
+
+// readCerts reads all x509 certificates found in the PEM file at path,
+// in the order they appear. Text and blocks of other types are skipped.
+func readCerts(path string) ([]*x509.Certificate, error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	d, _ := pem.Decode(b)
-	if d == nil {
-		return nil, fmt.Errorf("no block found in %q", path)
+	var res []*x509.Certificate
+	for {
+		var d *pem.Block
+		d, b = pem.Decode(b)
+		if d == nil {
+			break
+		}
+		if d.Type != x509PublicKey {
+			continue
+		}
+		c, err := x509.ParseCertificate(d.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		res = append(res, c)
 	}
-	if d.Type != x509PublicKey {
-		return nil, fmt.Errorf("%q is unsupported", d.Type)
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no certificate found in %q", path)
 	}
-	return x509.ParseCertificate(d.Bytes)
+	return res, nil
+}
+
+// readBlock returns the first PEM block of one of the given types
+// found in the file at path. Any text and blocks of other types
+// are skipped.
+func readBlock(path string, types ...string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for {
+		var d *pem.Block
+		d, b = pem.Decode(b)
+		if d == nil {
+			break
+		}
+		for _, t := range types {
+			if d.Type == t {
+				return d, nil
+			}
+		}
+		found = append(found, fmt.Sprintf("%q", d.Type))
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no PEM block found in %q", path)
+	}
+	return nil, fmt.Errorf("%q: no %s block found; found %s",
+		path, strings.Join(types, " or "), strings.Join(found, ", "))
 }
 
-// writeKey writes k to the specified path in PEM format.
+// writeKey writes k to the specified path in PEM format, see keyPEM.
 // If file does not exists, it will be created with 0600 mod.
-func writeKey(path string, k *rsa.PrivateKey) error {
+func writeKey(path string, k crypto.Signer, pass []byte) error {
+	b, err := keyPEM(k, pass)
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	bytes := x509.MarshalPKCS1PrivateKey(k)
-	b := &pem.Block{Type: rsaPrivateKey, Bytes: bytes}
 	if err := pem.Encode(f, b); err != nil {
 		f.Close()
 		return err
@@ -153,10 +445,167 @@ func writeKey(path string, k *rsa.PrivateKey) error {
 	return f.Close()
 }
 
+// keyFormatFlag is the -key-format flag selecting the encoding of written
+// private keys. The "pkcs1" format is an RSA PRIVATE KEY block for RSA keys,
+// or a SEC 1 EC PRIVATE KEY block for EC keys. The "pkcs8" format is
+// a PRIVATE KEY block for any key type. The zero value selects pkcs1
+// for RSA keys and pkcs8 for the others.
+type keyFormatFlag string
+
+func (f *keyFormatFlag) String() string {
+	return string(*f)
+}
+
+func (f *keyFormatFlag) Set(v string) error {
+	switch v {
+	case "pkcs1", "pkcs8":
+		*f = keyFormatFlag(v)
+		return nil
+	}
+	return fmt.Errorf("unknown key format %q; want pkcs1 or pkcs8", v)
+}
+
+// keyFormat is the format keyPEM encodes keys in.
+var keyFormat keyFormatFlag
+
+// keyPEM returns the PEM block of an RSA, EC or Ed25519 private key k
+// in keyFormat. If pass is not empty, the key is instead encrypted with it
+// as a PKCS#8 ENCRYPTED PRIVATE KEY block, see encryptPKCS8.
+func keyPEM(k crypto.Signer, pass []byte) (*pem.Block, error) {
+	if len(pass) > 0 {
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		b, err := encryptPKCS8(der, pass)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: encryptedPrivateKey, Bytes: b}, nil
+	}
+	var b *pem.Block
+	_, isRSA := k.(*rsa.PrivateKey)
+	if keyFormat == "pkcs8" || keyFormat == "" && !isRSA {
+		bytes, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		b = &pem.Block{Type: pkcs8PrivateKey, Bytes: bytes}
+	} else {
+		switch k := k.(type) {
+		case *rsa.PrivateKey:
+			b = &pem.Block{Type: rsaPrivateKey, Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		case *ecdsa.PrivateKey:
+			bytes, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			b = &pem.Block{Type: ecPrivateKey, Bytes: bytes}
+		default:
+			return nil, fmt.Errorf("key type %T has no %s format", k, keyFormat)
+		}
+	}
+	return b, nil
+}
+
+// writeFileAtomic writes b to a temporary file in the same dir as path
+// and renames it to path, so that readers never see a partial file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// backupFile copies the file at path to path.bak, replacing a previous backup.
+// It does nothing if the file does not exist.
+func backupFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+".bak", b, fi.Mode().Perm())
+}
+
+// keySpec describes a private key to generate.
+type keySpec struct {
+	Type string // "rsa" or "ec"
+	Bits int    // RSA modulus size or EC curve size; zero means default
+
+	// Encrypt stores the key encrypted with the keyPassEnv passphrase.
+	Encrypt bool
+}
+
+// keyPassEnv is the environment variable holding the passphrase
+// of encrypted keys.
+const keyPassEnv = "ACME_KEY_PASS"
+
+// accountKeySpec returns s, with encryption enabled if the keyPassEnv
+// passphrase is set. Certificate keys are only encrypted with -encrypt,
+// since they are usually read by the servers using the certificates.
+func accountKeySpec(s keySpec) keySpec {
+	s.Encrypt = os.Getenv(keyPassEnv) != ""
+	return s
+}
+
+// defaultKeySpec is used when no specific key type is requested.
+var defaultKeySpec = keySpec{Type: "rsa", Bits: 2048}
+
+// generate creates a new key according to s.
+func (s keySpec) generate() (crypto.Signer, error) {
+	switch s.Type {
+	case "rsa":
+		bits := s.Bits
+		if bits == 0 {
+			bits = defaultKeySpec.Bits
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA key size %d is too small", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ec":
+		var c elliptic.Curve
+		switch s.Bits {
+		case 0, 256:
+			c = elliptic.P256()
+		case 384:
+			c = elliptic.P384()
+		case 521:
+			c = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC key size %d", s.Bits)
+		}
+		return ecdsa.GenerateKey(c, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", s.Type)
+	}
+}
+
 // anyKey reads the key from file or generates a new one if gen == true.
 // It returns an error if filename exists but cannot be read.
-// A newly generated key is also stored to filename.
-func anyKey(filename string, gen bool) (crypto.Signer, error) {
+// A newly generated key is created according to spec and also stored to filename.
+func anyKey(filename string, gen bool, spec keySpec) (crypto.Signer, error) {
 	k, err := readKey(filename)
 	if err == nil {
 		return k, nil
@@ -164,11 +613,15 @@ func anyKey(filename string, gen bool) (crypto.Signer, error) {
 	if !os.IsNotExist(err) || !gen {
 		return nil, err
 	}
-	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	k, err = spec.generate()
 	if err != nil {
 		return nil, err
 	}
-	return rsaKey, writeKey(filename, rsaKey)
+	var pass []byte
+	if spec.Encrypt {
+		pass = []byte(os.Getenv(keyPassEnv))
+	}
+	return k, writeKey(filename, k, pass)
 }
 
 // sameDir returns filename path placing it in the same dir as existing file.
@@ -176,9 +629,9 @@ func sameDir(existing, filename string) string {
 	return filepath.Join(filepath.Dir(existing), filename)
 }
 
-// printAccount outputs account into into w using tabwriter.
+// printAccount outputs account into into w using fieldWriter.
 func printAccount(w io.Writer, a *acme.Account, kp string) {
-	tw := tabwriter.NewWriter(w, 0, 8, 0, '\t', 0)
+	tw := fieldWriter(w)
 	fmt.Fprintln(tw, "URI:\t", a.URI)
 	fmt.Fprintln(tw, "Key:\t", kp)
 	fmt.Fprintln(tw, "Contact:\t", strings.Join(a.Contact, ", "))
@@ -193,3 +646,25 @@ func printAccount(w io.Writer, a *acme.Account, kp string) {
 	// TODO: print authorization and certificates
 	tw.Flush()
 }
+
+// fieldWriter returns a tabwriter aligning "name:\t value" lines written to w.
+// If w is not a terminal, the fields are written unaligned as "name: value"
+// instead, which is easier to parse in scripts.
+func fieldWriter(w io.Writer) *tabwriter.Writer {
+	if f, ok := w.(*os.File); ok && isTerminal(f) {
+		return tabwriter.NewWriter(w, 0, 8, 0, '\t', 0)
+	}
+	return tabwriter.NewWriter(untabWriter{w}, 0, 8, 0, '\t', 0)
+}
+
+// untabWriter removes tabs from the text written to w.
+type untabWriter struct {
+	w io.Writer
+}
+
+func (u untabWriter) Write(p []byte) (int, error) {
+	if _, err := u.w.Write(bytes.Replace(p, []byte("\t"), nil, -1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
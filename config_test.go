@@ -12,8 +12,20 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/acme"
@@ -47,3 +59,343 @@ func TestConfigReadWrite(t *testing.T) {
 		t.Errorf("read: %+v\nwant: %+v", read, write)
 	}
 }
+
+func TestReadKeySkipsOtherBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []byte("Exported by some tool.\n")
+	b = append(b, pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{6, 8}})...)
+	b = append(b, pem.EncodeToMemory(&pem.Block{Type: ecPrivateKey, Bytes: der})...)
+	path := filepath.Join(dir, "test.key")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, k) {
+		t.Errorf("readKey returned a different key")
+	}
+
+	if _, err := readCrt(path); err == nil || !strings.Contains(err.Error(), `"EC PARAMETERS"`) {
+		t.Errorf("readCrt: err = %v; want it to list found block types", err)
+	}
+}
+
+func TestConfigMultipleCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, ca discoAliasFlag) { configDir, configCA = d, ca }(configDir, configCA)
+	configDir = dir
+	prod := &userConfig{
+		Account: acme.Account{URI: "https://prod/reg/1"},
+		CA:      discoAliases["letsencrypt"],
+	}
+	staging := &userConfig{
+		Account: acme.Account{URI: "https://staging/reg/2"},
+		CA:      discoAliases["letsencrypt-staging"],
+		Key:     "staging.key",
+	}
+	for _, uc := range []*userConfig{prod, staging} {
+		if err := writeConfig(uc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ca   string
+		want *userConfig
+	}{
+		{"", prod},
+		{"letsencrypt", prod},
+		{"letsencrypt-staging", staging},
+	}
+	for _, test := range tests {
+		configCA = ""
+		if test.ca != "" {
+			configCA.Set(test.ca)
+		}
+		uc, err := readConfig()
+		if err != nil {
+			t.Errorf("%q: %v", test.ca, err)
+			continue
+		}
+		if uc.URI != test.want.URI || uc.CA != test.want.CA || uc.keyPath() != test.want.keyPath() {
+			t.Errorf("%q: read %+v; want %+v", test.ca, uc, test.want)
+		}
+	}
+
+	configCA = "https://unknown"
+	if _, err := readConfig(); err == nil {
+		t.Error("readConfig with unknown CA: nil error")
+	}
+}
+
+func TestAnyKeyGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, spec := range []keySpec{defaultKeySpec, {Type: "ec"}, {Type: "ec", Bits: 384}} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.key", i))
+		k, err := anyKey(path, true, spec)
+		if err != nil {
+			t.Errorf("%+v: %v", spec, err)
+			continue
+		}
+		read, err := readKey(path)
+		if err != nil {
+			t.Errorf("%+v: readKey: %v", spec, err)
+			continue
+		}
+		if !sameKey(read, k) {
+			t.Errorf("%+v: read key differs from the generated one", spec)
+		}
+	}
+	if _, err := anyKey(filepath.Join(dir, "dsa.key"), true, keySpec{Type: "dsa"}); err == nil {
+		t.Error("anyKey with unsupported type: nil error")
+	}
+}
+
+func TestReadKeyPKCS8(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{pkcs8PrivateKey, rsaPrivateKey, "ENCODED KEY"} {
+		path := filepath.Join(dir, "test.key")
+		b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		key, err := readKey(path)
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if !reflect.DeepEqual(key, k) {
+			t.Errorf("%s: readKey returned a different key", typ)
+		}
+	}
+}
+
+func TestConfigNoDir(t *testing.T) {
+	defer func(dir string) { configDir = dir }(configDir)
+	configDir = ""
+	if _, err := readConfig(); err != errNoConfigDir {
+		t.Errorf("readConfig: err = %v; want %v", err, errNoConfigDir)
+	}
+	if err := writeConfig(&userConfig{}); err != errNoConfigDir {
+		t.Errorf("writeConfig: err = %v; want %v", err, errNoConfigDir)
+	}
+}
+
+func TestReadKeyEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(keyPassEnv, os.Getenv(keyPassEnv))
+	os.Setenv(keyPassEnv, "secret")
+
+	path := filepath.Join(dir, "account.key")
+	k, err := anyKey(path, true, accountKeySpec(keySpec{Type: "ec"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := pem.Decode(b); d == nil || d.Type != encryptedPrivateKey {
+		t.Fatal("stored key is not an encrypted PKCS#8 key")
+	}
+	read, err := readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(read, k) {
+		t.Error("read key differs from the generated one")
+	}
+
+	os.Setenv(keyPassEnv, "wrong")
+	if _, err := readKey(path); err == nil {
+		t.Error("readKey with wrong passphrase: nil error")
+	}
+
+	// keys encrypted by earlier versions are still read
+	der, err := x509.MarshalECPrivateKey(k.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, ecPrivateKey, der, []byte("old"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(dir, "legacy.key")
+	if err := ioutil.WriteFile(legacyPath, pem.EncodeToMemory(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(keyPassEnv, "old")
+	if read, err := readKey(legacyPath); err != nil || !sameKey(read, k) {
+		t.Errorf("readKey of a legacy encrypted key: %v", err)
+	}
+	os.Setenv(keyPassEnv, "")
+	if _, err := readKey(path); err == nil {
+		t.Error("readKey with no passphrase: nil error")
+	}
+}
+
+func TestKeyPolicy(t *testing.T) {
+	for _, test := range []struct {
+		spec keySpec
+		ok   bool
+	}{
+		{defaultKeySpec, true},
+		{keySpec{Type: "ec", Bits: 384}, true},
+		{keySpec{Type: "ec", Bits: 521}, false},
+	} {
+		k, err := test.spec.generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyPolicy(k); (err == nil) != test.ok {
+			t.Errorf("%+v: keyPolicy = %v; want ok = %v", test.spec, err, test.ok)
+		}
+	}
+}
+
+func TestConfigKeyName(t *testing.T) {
+	defer func(d string) { configDir = d }(configDir)
+	configDir = "/etc/acme"
+	tests := []struct{ path, name string }{
+		{"/etc/acme/account.key", "account.key"},
+		{"/etc/acme/keys/a.key", "keys/a.key"},
+		{"/etc/acme.key", "/etc/acme.key"},
+		{"/etc/acme/..keys/a.key", "..keys/a.key"},
+	}
+	for _, test := range tests {
+		name, err := configKeyName(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != test.name {
+			t.Errorf("configKeyName(%q) = %q; want %q", test.path, name, test.name)
+		}
+		uc := &userConfig{Key: name}
+		if p := uc.keyPath(); p != filepath.Clean(test.path) {
+			t.Errorf("%q: keyPath = %q; want %q", name, p, filepath.Clean(test.path))
+		}
+	}
+}
+
+func TestPrintAccountPlain(t *testing.T) {
+	var buf bytes.Buffer
+	a := &acme.Account{URI: "https://ca/reg/1", Contact: []string{"mailto:a@example.org"}}
+	printAccount(&buf, a, "/etc/acme/account.key")
+	want := `URI: https://ca/reg/1
+Key: /etc/acme/account.key
+Contact: mailto:a@example.org
+Terms: 
+Accepted: no
+`
+	if buf.String() != want {
+		t.Errorf("printAccount output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	defer func(r bool, c curveFlag, ch challengeFlag, dns bool) {
+		certReuseKey, certCurve, certChallenge, certDNS = r, c, ch, dns
+	}(certReuseKey, certCurve, certChallenge, certDNS)
+	certReuseKey, certCurve, certChallenge, certDNS = true, 0, challengeFlag{}, false
+
+	fs := flag.NewFlagSet("cert", flag.ContinueOnError)
+	fs.Var(&certCurve, "cert-curve", "")
+	fs.BoolVar(&certReuseKey, "reuse-key", certReuseKey, "")
+	if err := fs.Parse([]string{"-cert-curve", "P-256"}); err != nil {
+		t.Fatal(err)
+	}
+	reuse := false
+	d := &certDefaults{ReuseKey: &reuse, KeyType: "P-384", Challenge: "dns-01"}
+	if err := applyDefaults(fs, d); err != nil {
+		t.Fatal(err)
+	}
+	if certReuseKey {
+		t.Error("certReuseKey = true; want stored false")
+	}
+	if certCurve != 256 {
+		t.Errorf("certCurve = %v; want P-256 from the command line", &certCurve)
+	}
+	if typ, _ := challengeType("example.org"); typ != "dns-01" {
+		t.Errorf("challengeType = %q; want stored dns-01", typ)
+	}
+	empty := flag.NewFlagSet("cert", flag.ContinueOnError)
+	if err := applyDefaults(empty, &certDefaults{KeyType: "P-224"}); err == nil {
+		t.Error("applyDefaults with invalid key type: nil error")
+	}
+}
+
+func TestKeyPEMFormat(t *testing.T) {
+	defer func(f keyFormatFlag) { keyFormat = f }(keyFormat)
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := defaultKeySpec.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format keyFormatFlag
+		key    crypto.Signer
+		want   string
+	}{
+		{"", rsaKey, rsaPrivateKey},
+		{"", ec, pkcs8PrivateKey},
+		{"pkcs1", rsaKey, rsaPrivateKey},
+		{"pkcs1", ec, ecPrivateKey},
+		{"pkcs8", rsaKey, pkcs8PrivateKey},
+		{"pkcs8", ec, pkcs8PrivateKey},
+	}
+	for _, test := range tests {
+		keyFormat = test.format
+		b, err := keyPEM(test.key, nil)
+		if err != nil {
+			t.Errorf("%q %T: %v", test.format, test.key, err)
+			continue
+		}
+		if b.Type != test.want {
+			t.Errorf("%q %T: type %q; want %q", test.format, test.key, b.Type, test.want)
+		}
+		if _, err := parseKey(b); err != nil {
+			t.Errorf("%q %T: parseKey: %v", test.format, test.key, err)
+		}
+	}
+	if err := keyFormat.Set("der"); err == nil {
+		t.Error("Set(der): nil error")
+	}
+}
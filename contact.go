@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// contactList is a flag collecting account contact URIs, such as
+// mailto:admin@example.org or tel:+12025550100.
+// It may be repeated. The URIs are passed to the CA unchanged.
+type contactList []string
+
+func (c *contactList) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *contactList) Set(v string) error {
+	if err := checkContact(v); err != nil {
+		return err
+	}
+	*c = append(*c, v)
+	return nil
+}
+
+// emailList is a flag adding email addresses to a contactList
+// as mailto URIs.
+type emailList struct {
+	c *contactList
+}
+
+func (e emailList) String() string {
+	if e.c == nil {
+		return ""
+	}
+	return e.c.String()
+}
+
+func (e emailList) Set(v string) error {
+	if strings.Contains(v, ":") {
+		return fmt.Errorf("email %q must not include a scheme; use -contact instead", v)
+	}
+	return e.c.Set("mailto:" + v)
+}
+
+// checkContact returns an error if v is not an absolute URI.
+// Any scheme is accepted, since CAs may support contact types
+// other than email.
+func checkContact(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return fmt.Errorf("contact %q: %v", v, err)
+	}
+	if u.Scheme == "" || (u.Opaque == "" && u.Host == "" && u.Path == "") {
+		return fmt.Errorf("contact %q is not a URI; use scheme:value form, such as mailto:%s", v, v)
+	}
+	return nil
+}
+
+// contacts returns the contacts in args, which must all be URIs,
+// followed by those in list.
+func contacts(args []string, list contactList) ([]string, error) {
+	res := make([]string, 0, len(args)+len(list))
+	for _, a := range args {
+		if err := checkContact(a); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return append(res, list...), nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	cmdCRLCheck = &command{
+		run:       runCRLCheck,
+		UsageLine: "crl-check [-max-age dur] file",
+		Short:     "check a certificate against its CRL",
+		Long: `
+Crl-check downloads the certificate revocation list (CRL) of the first
+certificate in the PEM file, from the CRL distribution point named in
+the certificate, and reports whether the certificate is listed as revoked.
+
+If the file also holds the issuer certificate, as a full chain does,
+the CRL signature is verified with it. Otherwise a warning is printed
+that the CRL is used unverified.
+
+Downloaded CRLs are cached in the user cache dir and reused for
+-max-age, {{.CRLMaxAge}} by default, or until the CRL's next update time,
+whichever is earlier. Zero -max-age disables the cache.
+CRLs are at most {{.CRLMaxSize}} MiB.
+
+Crl-check exits with a non-zero code if the certificate is revoked,
+has no CRL distribution point, or the CRL cannot be checked.
+`,
+	}
+
+	crlMaxAge = time.Hour
+)
+
+// crlMaxSize is the maximum size of a downloaded CRL.
+const crlMaxSize = 256 << 20
+
+func init() {
+	cmdCRLCheck.flag.DurationVar(&crlMaxAge, "max-age", crlMaxAge, "")
+}
+
+func runCRLCheck(args []string) {
+	if len(args) != 1 {
+		fatalf("crl-check requires exactly one file argument")
+	}
+	crts, err := readCerts(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	crt := crts[0]
+	if len(crt.CRLDistributionPoints) == 0 {
+		fatalf("%s: the certificate has no CRL distribution point", args[0])
+	}
+	var issuer *x509.Certificate
+	for _, c := range crts[1:] {
+		if crt.CheckSignatureFrom(c) == nil {
+			issuer = c
+			break
+		}
+	}
+	if issuer == nil {
+		logf("warning: no issuer certificate in %s; the CRL signature is not verified", args[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	var errs []error
+	for _, u := range crt.CRLDistributionPoints {
+		rl, err := loadCRL(ctx, u, issuer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", u, err))
+			continue
+		}
+		e := crlEntry(rl, crt)
+		if e == nil {
+			fmt.Printf("not revoked: serial %X is not in the CRL at %s, updated %s\n",
+				crt.SerialNumber, u, rl.ThisUpdate.Format(time.RFC3339))
+			return
+		}
+		fmt.Printf("revoked: serial %X at %s, reason %s\n",
+			crt.SerialNumber, e.RevocationTime.Format(time.RFC3339), crlReason(e.ReasonCode))
+		setExitStatus(1)
+		return
+	}
+	for _, err := range errs {
+		errorf("%v", err)
+	}
+}
+
+// crlEntry returns the entry of crt in rl, or nil if it is not revoked.
+func crlEntry(rl *x509.RevocationList, crt *x509.Certificate) *x509.RevocationListEntry {
+	for i, e := range rl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+			return &rl.RevokedCertificateEntries[i]
+		}
+	}
+	return nil
+}
+
+// crlReason returns the name of an RFC 5280 reason code.
+func crlReason(code int) string {
+	for name, c := range revokeReasons {
+		if int(c) == code {
+			return name
+		}
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// loadCRL returns the CRL at url, reusing a cached copy unless it
+// is older than crlMaxAge or past its next update time.
+// The CRL signature is verified with issuer, if not nil.
+func loadCRL(ctx context.Context, url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	cache := crlCachePath(url)
+	if fi, err := os.Stat(cache); err == nil && time.Since(fi.ModTime()) < crlMaxAge {
+		rl, err := readCRL(cache, issuer)
+		if err == nil && (rl.NextUpdate.IsZero() || time.Now().Before(rl.NextUpdate)) {
+			return rl, nil
+		}
+	}
+	path, err := downloadCRL(ctx, url, cache)
+	if err != nil {
+		return nil, err
+	}
+	if path != cache {
+		defer os.Remove(path)
+	}
+	return readCRL(path, issuer)
+}
+
+// crlCachePath returns the cache file of the CRL at url,
+// or an empty string if the cache is disabled.
+func crlCachePath(url string) string {
+	if crlMaxAge <= 0 {
+		return ""
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "acme", "crl", hex.EncodeToString(h[:])+".crl")
+}
+
+// downloadCRL stores the CRL at url into the cache file path, streaming it
+// so that large CRLs are not held in memory twice. If path is empty,
+// or the cache dir cannot be created, a temporary file is used instead.
+// It returns the file the CRL was stored to.
+func downloadCRL(ctx context.Context, url, path string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: newTransport()}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", res.Status)
+	}
+
+	dir := os.TempDir()
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			dir = filepath.Dir(path)
+		} else {
+			path = ""
+		}
+	}
+	f, err := ioutil.TempFile(dir, "crl.tmp")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(res.Body, crlMaxSize+1))
+	if err == nil && n > crlMaxSize {
+		err = fmt.Errorf("CRL exceeds %d bytes", crlMaxSize)
+	}
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err == nil && path != "" && os.Rename(f.Name(), path) == nil {
+		return path, nil
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// readCRL parses the DER or PEM encoded CRL in the file at path,
+// verifying its signature with issuer, if not nil.
+func readCRL(path string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if d, _ := pem.Decode(b); d != nil && d.Type == "X509 CRL" {
+		b = d.Bytes
+	}
+	rl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	if issuer != nil {
+		if err := rl.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("CRL signature: %v", err)
+		}
+	}
+	return rl, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadCRL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(42), RevocationTime: now.Add(-time.Minute), ReasonCode: 1},
+		},
+	}, ca, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(crl)
+	}))
+	defer ts.Close()
+
+	defer func(d time.Duration) { crlMaxAge = d }(crlMaxAge)
+	crlMaxAge = 0
+	rl, err := loadCRL(context.Background(), ts.URL, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := crlEntry(rl, &x509.Certificate{SerialNumber: big.NewInt(42)})
+	if e == nil {
+		t.Fatal("serial 42 not found")
+	}
+	if r := crlReason(e.ReasonCode); r != "keyCompromise" {
+		t.Errorf("reason %q; want keyCompromise", r)
+	}
+	if e := crlEntry(rl, &x509.Certificate{SerialNumber: big.NewInt(43)}); e != nil {
+		t.Errorf("serial 43 listed as revoked at %v", e.RevocationTime)
+	}
+
+	other := testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Other CA"}})
+	if _, err := loadCRL(context.Background(), ts.URL, other); err == nil {
+		t.Error("CRL signed by another CA: nil error")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+var (
+	cmdDefaults = &command{
+		run:       runDefaults,
+		UsageLine: "defaults [-c config] [-reuse-key=bool] [-cert-key rsa|P-256|P-384|P-521] [-challenge type] [-clear]",
+		Short:     "set stored defaults of the cert command",
+		Long: `
+Defaults stores defaults for the cert and rekey commands in {{.AccountFile}},
+for the account selected with -ca, so they need not be specified every time.
+Flags given to those commands override the stored defaults.
+
+The -reuse-key flag sets whether renewals keep the existing certificate key;
+see cert -reuse-key. The -cert-key flag sets the type of new certificate keys,
+either rsa or a curve name as for cert -cert-curve. It does not affect
+account keys, whose type is chosen with genkey, init or reg -keytype.
+The -challenge flag sets the challenge type, http-01 or dns-01,
+for domains with no other choice.
+The -clear flag removes all stored defaults first.
+
+Without flags, the current defaults are printed.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	defaultsReuseKey  bool
+	defaultsCertKey   string
+	defaultsChallenge string
+	defaultsClear     bool
+	defaultsFlags     *flag.FlagSet // cmdDefaults.flag, to tell which flags are set
+)
+
+func init() {
+	cmdDefaults.flag.BoolVar(&defaultsReuseKey, "reuse-key", true, "")
+	cmdDefaults.flag.StringVar(&defaultsCertKey, "cert-key", "", "")
+	cmdDefaults.flag.StringVar(&defaultsChallenge, "challenge", "", "")
+	cmdDefaults.flag.BoolVar(&defaultsClear, "clear", defaultsClear, "")
+	defaultsFlags = &cmdDefaults.flag
+}
+
+// certDefaults are defaults of the cert and rekey commands stored
+// with an account. Empty fields leave the flag defaults unchanged.
+type certDefaults struct {
+	ReuseKey  *bool  `json:"reuseKey,omitempty"`
+	KeyType   string `json:"keyType,omitempty"`   // "rsa" or a -cert-curve value
+	Challenge string `json:"challenge,omitempty"` // "http-01" or "dns-01"
+}
+
+func runDefaults(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	set := setFlags(defaultsFlags)
+	if len(set) == 0 {
+		printDefaults(uc.Defaults)
+		return
+	}
+
+	d := uc.Defaults
+	if d == nil || defaultsClear {
+		d = &certDefaults{}
+	}
+	if set["reuse-key"] {
+		reuse := defaultsReuseKey
+		d.ReuseKey = &reuse
+	}
+	if set["cert-key"] {
+		if defaultsCertKey != "rsa" {
+			var c curveFlag
+			if err := c.Set(defaultsCertKey); err != nil {
+				fatalf("-cert-key: %v", err)
+			}
+		}
+		d.KeyType = defaultsCertKey
+	}
+	if set["challenge"] {
+		if err := (challengeFlag{}).Set(defaultsChallenge); err != nil {
+			fatalf("-challenge: %v", err)
+		}
+		d.Challenge = defaultsChallenge
+	}
+	uc.Defaults = d
+	if *d == (certDefaults{}) {
+		uc.Defaults = nil
+	}
+	if err := writeConfig(uc); err != nil {
+		fatalf("write config: %v", err)
+	}
+	printDefaults(uc.Defaults)
+}
+
+// printDefaults outputs d into the standard output using fieldWriter.
+func printDefaults(d *certDefaults) {
+	if d == nil {
+		d = &certDefaults{}
+	}
+	reuse := ""
+	if d.ReuseKey != nil {
+		reuse = strconv.FormatBool(*d.ReuseKey)
+	}
+	tw := fieldWriter(os.Stdout)
+	fmt.Fprintln(tw, "Reuse key:\t", reuse)
+	fmt.Fprintln(tw, "Cert key:\t", d.KeyType)
+	fmt.Fprintln(tw, "Challenge:\t", d.Challenge)
+	tw.Flush()
+}
+
+// setFlags returns the names of the flags set on the command line of fs.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// applyDefaults sets the cert command flags to the stored defaults d,
+// unless they were set on the command line of fs.
+func applyDefaults(fs *flag.FlagSet, d *certDefaults) error {
+	if d == nil {
+		return nil
+	}
+	set := setFlags(fs)
+	if d.ReuseKey != nil && !set["reuse-key"] {
+		certReuseKey = *d.ReuseKey
+	}
+	if d.KeyType != "" && !set["cert-curve"] {
+		if d.KeyType == "rsa" {
+			certCurve = 0
+		} else if err := certCurve.Set(d.KeyType); err != nil {
+			return fmt.Errorf("stored cert key type: %v", err)
+		}
+	}
+	if d.Challenge != "" && certChallenge[""] == "" && !certDNS {
+		if err := certChallenge.Set(d.Challenge); err != nil {
+			return fmt.Errorf("stored challenge: %v", err)
+		}
+	}
+	return nil
+}
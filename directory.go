@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+var (
+	cmdDirectory = &command{
+		run:       runDirectory,
+		UsageLine: "directory [-d url]",
+		Short:     "print the CA directory",
+		Long: `
+Directory fetches the directory of the CA specified with -d argument
+and prints it as indented JSON, exactly as served by the CA.
+The default is {{.DefaultDisco}}, or the CA selected with -ca.
+For more information about the discovery run acme help disco.
+
+The directory lists the endpoints and metadata of the CA, such as
+its terms of service and CAA identities. If the metadata state that
+an external account binding is required, a note is printed to the
+standard error, since registering such accounts is not supported.
+`,
+	}
+
+	directoryDisco = defaultDiscoFlag
+)
+
+func init() {
+	cmdDirectory.flag.Var(&directoryDisco, "d", "")
+}
+
+func runDirectory(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	url := string(directoryDisco)
+	if configCA != "" {
+		url = string(configCA)
+	}
+	b, err := fetch(ctx, url, 1<<20)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		fatalf("%s: %v", url, err)
+	}
+	buf.WriteByte('\n')
+	os.Stdout.Write(buf.Bytes())
+
+	var v struct {
+		Meta struct {
+			EAB bool `json:"externalAccountRequired"`
+		} `json:"meta"`
+	}
+	if json.Unmarshal(b, &v) == nil && v.Meta.EAB {
+		logf("note: the CA requires external account binding")
+	}
+}
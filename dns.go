@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// dnsResolver is the DNS server used for lookups done by the program
+// itself, such as in dns-01 propagation checks and by the doctor command.
+// It does not affect the CA's validation.
+// If unset, the system resolver is used.
+// It may be set using -resolver flag, common to all subcommands.
+var dnsResolver resolverFlag
+
+// resolverFlag is a flag holding the host:port address of a DNS server.
+// The port defaults to 53.
+type resolverFlag struct {
+	addr string
+}
+
+func (f *resolverFlag) String() string {
+	return f.addr
+}
+
+func (f *resolverFlag) Set(v string) error {
+	host, port, err := net.SplitHostPort(v)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]"), "53"
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return fmt.Errorf("resolver %q: want host:port", v)
+	}
+	if _, err := net.LookupPort("udp", port); err != nil {
+		return fmt.Errorf("resolver %q: %v", v, err)
+	}
+	f.addr = net.JoinHostPort(host, port)
+	return nil
+}
+
+// resolver returns a resolver querying the DNS server in f,
+// or net.DefaultResolver if f is unset.
+func (f *resolverFlag) resolver() *net.Resolver {
+	if f.addr == "" {
+		return net.DefaultResolver
+	}
+	addr := f.addr
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// waitPropagation polls the authoritative nameservers of the _acme-challenge
+// record of domain until all of them serve a TXT record with the value,
+// or certDNSTimeout elapses. A CNAME delegating the record is followed.
+// It does nothing if certDNSTimeout is not positive.
+// If the parent ctx is done, it returns ctx.Err() promptly.
+func waitPropagation(parent context.Context, domain, value string) error {
+	if certDNSTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(parent, certDNSTimeout)
+	defer cancel()
+
+	name := "_acme-challenge." + domain
+	if cname, err := dnsResolver.resolver().LookupCNAME(ctx, name); err == nil {
+		name = strings.TrimSuffix(cname, ".")
+	}
+	ns, err := authoritativeNS(ctx, name)
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		var pending []string
+		for _, h := range ns {
+			if !hasTXT(ctx, h, name, value) {
+				pending = append(pending, h)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err := parent.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("TXT record %s not found at %s", name, strings.Join(pending, ", "))
+		case <-time.After(certDNSPoll):
+		}
+	}
+}
+
+// authoritativeNS returns the nameservers of the zone name belongs to,
+// found by looking up NS records of name and its parents.
+func authoritativeNS(ctx context.Context, name string) ([]string, error) {
+	for n := name; strings.Contains(n, "."); n = n[strings.Index(n, ".")+1:] {
+		ns, err := dnsResolver.resolver().LookupNS(ctx, n)
+		if err != nil || len(ns) == 0 {
+			continue
+		}
+		hosts := make([]string, len(ns))
+		for i, v := range ns {
+			hosts[i] = strings.TrimSuffix(v.Host, ".")
+		}
+		return hosts, nil
+	}
+	return nil, fmt.Errorf("no nameservers found for %s", name)
+}
+
+// hasTXT reports whether the nameserver ns serves a TXT record
+// for name with the value. The address of ns is looked up with dnsResolver.
+func hasTXT(ctx context.Context, ns, name, value string) bool {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Resolver: dnsResolver.resolver()}
+			return d.DialContext(ctx, network, net.JoinHostPort(ns, "53"))
+		},
+	}
+	txt, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		return false
+	}
+	for _, v := range txt {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
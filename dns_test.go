@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolverFlag(t *testing.T) {
+	for v, want := range map[string]string{
+		"192.0.2.1":      "192.0.2.1:53",
+		"192.0.2.1:5353": "192.0.2.1:5353",
+		"[2001:db8::1]":  "[2001:db8::1]:53",
+		"ns.example.org": "ns.example.org:53",
+	} {
+		var f resolverFlag
+		if err := f.Set(v); err != nil {
+			t.Errorf("Set(%q): %v", v, err)
+			continue
+		}
+		if f.addr != want {
+			t.Errorf("Set(%q) = %q; want %q", v, f.addr, want)
+		}
+	}
+	for _, v := range []string{"", ":53", "192.0.2.1:dns-port", "http://ns"} {
+		var f resolverFlag
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): nil error", v)
+		}
+	}
+	var f resolverFlag
+	if f.resolver() != net.DefaultResolver {
+		t.Error("unset resolver is not net.DefaultResolver")
+	}
+}
+
+func TestResolverDialsServer(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	got := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 512)
+		_, _, err := pc.ReadFrom(buf)
+		got <- err == nil
+	}()
+
+	var f resolverFlag
+	if err := f.Set(pc.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	f.resolver().LookupHost(ctx, "example.org")
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Error("no query received")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no query sent to the resolver")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+var (
+	cmdDNSTest = &command{
+		run:       runDNSTest,
+		UsageLine: "dns-test -acme-dns file [-dns-propagation-timeout dur] [-dns-poll-interval dur] domain",
+		Short:     "test dns-01 provider credentials",
+		Long: `
+Dns-test verifies that dns-01 challenges for the domain can be solved
+automatically, without requesting a certificate from the CA.
+
+It publishes a TXT record with a random value for the domain using
+the acme-dns credentials in the file specified with -acme-dns argument,
+and waits for the record to appear at the authoritative nameservers,
+reporting the outcome of each step. See acme help cert for details
+about the -acme-dns, -dns-propagation-timeout and -dns-poll-interval
+arguments.
+
+The test value is not removed. The acme-dns server only keeps
+the two most recent values, so it is replaced by subsequent challenges.
+`,
+	}
+)
+
+func init() {
+	cmdDNSTest.flag.StringVar(&certAcmeDNSFile, "acme-dns", "", "")
+	cmdDNSTest.flag.DurationVar(&certDNSTimeout, "dns-propagation-timeout", certDNSTimeout, "")
+	cmdDNSTest.flag.DurationVar(&certDNSPoll, "dns-poll-interval", certDNSPoll, "")
+}
+
+func runDNSTest(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one domain")
+	}
+	if certAcmeDNSFile == "" {
+		fatalf("no provider credentials specified; use -acme-dns")
+	}
+	domain, err := normalizeDomain(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	m, err := readAcmeDNS(certAcmeDNSFile)
+	if err != nil {
+		fatalf("acme-dns: %v", err)
+	}
+	a, ok := m[domain]
+	if !ok {
+		fatalf("no acme-dns credentials for %s in %s", domain, certAcmeDNSFile)
+	}
+
+	ctx, stop := withSignals(context.Background())
+	defer stop()
+	step := func(name string, f func() error) {
+		start := time.Now()
+		if err := f(); err != nil {
+			fmt.Printf("%s: FAIL\n", name)
+			fatalf("%v", err)
+		}
+		fmt.Printf("%s: ok (%dms)\n", name, time.Since(start)/time.Millisecond)
+	}
+
+	step("delegation", func() error {
+		return a.checkDelegation(ctx, domain)
+	})
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		fatalf("%v", err)
+	}
+	val := base64.RawURLEncoding.EncodeToString(b)
+	step("update", func() error {
+		return a.update(ctx, val)
+	})
+	step("propagation", func() error {
+		return waitPropagation(ctx, domain, val)
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	cmdDoctor = &command{
+		run:       runDoctor,
+		UsageLine: "doctor [-c config] [-d url] [-s host:port] [domain ...]",
+		Short:     "diagnose common issuance failures",
+		Long: `
+Doctor checks for common causes of failed cert runs and prints the
+problems found, most likely causes first. It changes nothing.
+
+The checks are:
+
+	- the CA directory, specified with -d or -ca, can be fetched
+	- the account config and key can be read
+	- no challenge artifacts were left behind by an interrupted run
+	- each domain resolves to an address
+	- port 80 of each domain accepts connections from this host
+	- the local http-01 server address, specified with -s, can be bound
+
+Reachability is tested from this host only, so a firewall blocking the
+CA may go unnoticed. CAA records are not checked, since they cannot be
+looked up with the system resolver. Checks that cannot be performed, such
+as when offline, are reported as skipped rather than failed.
+
+Doctor exits with a non-zero code if any check failed.
+`,
+	}
+
+	doctorDisco = defaultDiscoFlag
+	doctorAddr  = certAddr
+)
+
+func init() {
+	cmdDoctor.flag.Var(&doctorDisco, "d", "")
+	cmdDoctor.flag.StringVar(&doctorAddr, "s", doctorAddr, "")
+}
+
+// diagnosis levels, in order of priority
+const (
+	diagFail = iota
+	diagWarn
+	diagSkip
+	diagOK
+)
+
+var diagLevels = []string{"FAIL", "WARN", "SKIP", "OK"}
+
+// diagnosis is the result of a doctor check.
+type diagnosis struct {
+	level  int
+	check  string
+	detail string
+}
+
+func runDoctor(args []string) {
+	domains, err := normalizeDomains(args)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	list := []diagnosis{checkDirectory(ctx), checkAccount(), checkJournal(), checkListen(doctorAddr)}
+	for _, d := range domains {
+		list = append(list, checkResolve(ctx, d)...)
+	}
+	printDiagnoses(os.Stdout, list)
+	for _, d := range list {
+		if d.level == diagFail {
+			setExitStatus(1)
+		}
+	}
+}
+
+// printDiagnoses outputs list into w using tabwriter,
+// sorted by level and otherwise in the order of the checks.
+func printDiagnoses(w io.Writer, list []diagnosis) {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].level < list[j].level })
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, d := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", diagLevels[d.level], d.check, d.detail)
+	}
+	tw.Flush()
+}
+
+func checkDirectory(ctx context.Context) diagnosis {
+	url := string(doctorDisco)
+	if configCA != "" {
+		url = string(configCA)
+	}
+	d := diagnosis{check: "directory"}
+	b, err := fetch(ctx, url, 1<<20)
+	if err != nil {
+		d.level, d.detail = diagFail, fmt.Sprintf("%v; check network access and the proxy", err)
+		return d
+	}
+	var dir map[string]interface{}
+	if err := json.Unmarshal(b, &dir); err != nil {
+		d.level, d.detail = diagFail, fmt.Sprintf("%s is not an ACME directory: %v", url, err)
+		return d
+	}
+	d.level, d.detail = diagOK, url
+	return d
+}
+
+func checkAccount() diagnosis {
+	d := diagnosis{check: "account"}
+	uc, err := readConfig()
+	switch {
+	case err != nil:
+		d.level, d.detail = diagFail, fmt.Sprintf("%v; run acme reg", err)
+	case uc.key == nil:
+		d.level, d.detail = diagFail, fmt.Sprintf("key %s cannot be read", uc.keyPath())
+	default:
+		d.level, d.detail = diagOK, uc.URI
+	}
+	return d
+}
+
+func checkJournal() diagnosis {
+	d := diagnosis{check: "leftovers"}
+	list, err := readJournal()
+	switch {
+	case err != nil:
+		d.level, d.detail = diagWarn, fmt.Sprintf("challenge journal: %v", err)
+	case len(list) > 0:
+		d.level, d.detail = diagWarn, fmt.Sprintf("%d challenge artifacts of an interrupted run; run acme cleanup", len(list))
+	default:
+		d.level, d.detail = diagOK, "none"
+	}
+	return d
+}
+
+func checkListen(addr string) diagnosis {
+	d := diagnosis{check: "listen " + addr}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		d.level, d.detail = diagFail, fmt.Sprintf("%v; another server may be running, use -manual or -webroot", err)
+		return d
+	}
+	ln.Close()
+	d.level, d.detail = diagOK, "http-01 server can be started"
+	return d
+}
+
+// checkResolve checks that domain resolves, and that its port 80
+// accepts connections.
+func checkResolve(ctx context.Context, domain string) []diagnosis {
+	res := diagnosis{check: "resolve " + domain}
+	addrs, err := dnsResolver.resolver().LookupHost(ctx, domain)
+	if err != nil {
+		if e, ok := err.(*net.DNSError); ok && (e.IsTimeout || e.IsTemporary) {
+			res.level, res.detail = diagSkip, err.Error()
+		} else {
+			res.level, res.detail = diagFail, fmt.Sprintf("%v; add an A or AAAA record", err)
+		}
+		return []diagnosis{res}
+	}
+	res.level, res.detail = diagOK, fmt.Sprint(addrs)
+
+	conn := diagnosis{check: "connect " + domain + ":80"}
+	dialer := net.Dialer{Resolver: dnsResolver.resolver()}
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	c, err := dialer.DialContext(cctx, "tcp", net.JoinHostPort(domain, "80"))
+	if err != nil {
+		conn.level, conn.detail = diagWarn, fmt.Sprintf("%v; http-01 validation needs port 80 open to the CA", err)
+	} else {
+		c.Close()
+		conn.level, conn.detail = diagOK, "port 80 is reachable from this host"
+	}
+	return []diagnosis{res, conn}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// normalizeDomains returns a sorted list of unique domain names
+// normalized with normalizeDomain.
+// It is applied to the domains given at issuance as well as to the names
+// found in an existing certificate, so that both produce identical sets.
+func normalizeDomains(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	res := make([]string, 0, len(names))
+	for _, n := range names {
+		d, err := normalizeDomain(n)
+		if err != nil {
+			return nil, err
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		res = append(res, d)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// readDomainsFile returns the lines of the file at path, or of the standard
+// input if path is "-", skipping blank lines and # comments.
+// The lines are not normalized.
+func readDomainsFile(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var res []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		res = append(res, l)
+	}
+	return res, s.Err()
+}
+
+// domainListFlag is a flag collecting domain names normalized with
+// normalizeDomain. It may be repeated.
+type domainListFlag []string
+
+func (l *domainListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *domainListFlag) Set(v string) error {
+	d, err := normalizeDomain(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, d)
+	return nil
+}
+
+// changeDomains returns the normalized set of names in base, with add
+// added and remove removed. It is an error to remove a name not in base,
+// or cn, which names the certificate, or to leave no names.
+func changeDomains(base []string, cn string, add, remove []string) ([]string, error) {
+	set := make(map[string]bool, len(base)+len(add))
+	for _, d := range base {
+		set[d] = true
+	}
+	for _, d := range remove {
+		if !set[d] {
+			return nil, fmt.Errorf("cannot remove %s: not in %s", d, strings.Join(base, ", "))
+		}
+		if d == cn {
+			return nil, fmt.Errorf("cannot remove %s: the certificate is named after it", d)
+		}
+		delete(set, d)
+	}
+	for _, d := range add {
+		set[d] = true
+	}
+	res := make([]string, 0, len(set))
+	for d := range set {
+		res = append(res, d)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no domains left")
+	}
+	return normalizeDomains(res)
+}
+
+// normalizeDomain lowercases name, strips a trailing dot and converts
+// every non-ASCII label to its punycode "xn--" form.
+func normalizeDomain(name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" {
+		return "", fmt.Errorf("empty domain name")
+	}
+	labels := strings.Split(name, ".")
+	for i, l := range labels {
+		if l == "" {
+			return "", fmt.Errorf("%q: empty label", name)
+		}
+		if isASCII(l) {
+			continue
+		}
+		p, err := punycode(l)
+		if err != nil {
+			return "", fmt.Errorf("%q: %v", name, err)
+		}
+		labels[i] = "xn--" + p
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Bootstring parameters for punycode, as defined in RFC 3492.
+const (
+	pcBase        = 36
+	pcTMin        = 1
+	pcTMax        = 26
+	pcSkew        = 38
+	pcDamp        = 700
+	pcInitialBias = 72
+	pcInitialN    = 128
+)
+
+// punycode encodes s using the punycode algorithm of RFC 3492.
+// The result does not include the "xn--" prefix.
+func punycode(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("invalid UTF-8")
+	}
+	input := []rune(s)
+	var out []byte
+	for _, r := range input {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := rune(pcInitialN), 0, pcInitialBias
+	for h < len(input) {
+		m := rune(utf8.MaxRune)
+		for _, r := range input {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := pcBase; ; k += pcBase {
+				t := k - bias
+				if t < pcTMin {
+					t = pcTMin
+				} else if t > pcTMax {
+					t = pcTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, pcDigit(t+(q-t)%(pcBase-t)))
+				q = (q - t) / (pcBase - t)
+			}
+			out = append(out, pcDigit(q))
+			bias = pcAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func pcDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func pcAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= pcDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((pcBase-pcTMin)*pcTMax)/2 {
+		delta /= pcBase - pcTMin
+		k += pcBase
+	}
+	return k + (pcBase-pcTMin+1)*delta/(delta+pcSkew)
+}
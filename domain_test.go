@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Example.COM.", "example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"München.de", "xn--mnchen-3ya.de"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+	}
+	for _, test := range tests {
+		got, err := normalizeDomain(test.in)
+		if err != nil {
+			t.Errorf("normalizeDomain(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("normalizeDomain(%q) = %q; want %q", test.in, got, test.want)
+		}
+	}
+	if _, err := normalizeDomain("a..b"); err == nil {
+		t.Error("normalizeDomain(a..b): nil error")
+	}
+}
+
+func TestRenewSameDomains(t *testing.T) {
+	args := []string{"Bücher.example", "www.bücher.example", "bücher.example", "a.example"}
+	issued, err := normalizeDomains(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issue a certificate the way a CA would, with the names reordered
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: issued[1]},
+		DNSNames:     []string{issued[2], issued[0], issued[1]},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := certDomains(crt)
+	if !reflect.DeepEqual(renewed, issued) {
+		t.Errorf("renewed = %q; want %q", renewed, issued)
+	}
+	if !sameDomains(renewed, issued) {
+		t.Errorf("sameDomains(%q, %q) = false", renewed, issued)
+	}
+}
+
+func TestChangeDomains(t *testing.T) {
+	base := []string{"example.org", "www.example.org"}
+	tests := []struct {
+		add, remove []string
+		want        []string
+	}{
+		{[]string{"api.example.org"}, nil, []string{"api.example.org", "example.org", "www.example.org"}},
+		{nil, []string{"www.example.org"}, []string{"example.org"}},
+		{[]string{"example.org"}, []string{"www.example.org"}, []string{"example.org"}},
+	}
+	for _, test := range tests {
+		got, err := changeDomains(base, "example.org", test.add, test.remove)
+		if err != nil {
+			t.Errorf("add %q, remove %q: %v", test.add, test.remove, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("add %q, remove %q: %q; want %q", test.add, test.remove, got, test.want)
+		}
+	}
+	for _, remove := range []string{"example.org", "example.net"} {
+		if _, err := changeDomains(base, "example.org", nil, []string{remove}); err == nil {
+			t.Errorf("remove %s: nil error", remove)
+		}
+	}
+}
+
+func TestReadDomainsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "acme-domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "# managed names\nexample.org\n\n  www.example.org  \n#old.example.org\n")
+	f.Close()
+	got, err := readDomainsFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.org", "www.example.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readDomainsFile = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// oidExtKeyUsage is the extended key usage extension, RFC 5280 section 4.2.1.12.
+var oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// ekuOIDs maps the extended key usages which can be requested
+// to their object identifiers.
+var ekuOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageServerAuth: {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth: {1, 3, 6, 1, 5, 5, 7, 3, 2},
+}
+
+// ekuFlag selects the extended key usages requested for a certificate.
+// It is set with values of "server", "client" or "both".
+// The empty value leaves the choice to the CA.
+type ekuFlag string
+
+func (e *ekuFlag) String() string {
+	return string(*e)
+}
+
+func (e *ekuFlag) Set(v string) error {
+	switch v {
+	case "server", "client", "both":
+	default:
+		return fmt.Errorf("unsupported key usage %q; want server, client or both", v)
+	}
+	*e = ekuFlag(v)
+	return nil
+}
+
+// usages returns the extended key usages selected with e.
+func (e ekuFlag) usages() []x509.ExtKeyUsage {
+	switch e {
+	case "server":
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case "client":
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case "both":
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	return nil
+}
+
+// extensions returns the CSR extensions requesting the usages selected with e.
+// The result is nil if e is empty.
+func (e ekuFlag) extensions() ([]pkix.Extension, error) {
+	usages := e.usages()
+	if len(usages) == 0 {
+		return nil, nil
+	}
+	oids := make([]asn1.ObjectIdentifier, len(usages))
+	for i, u := range usages {
+		oids[i] = ekuOIDs[u]
+	}
+	b, err := asn1.Marshal(oids)
+	if err != nil {
+		return nil, err
+	}
+	return []pkix.Extension{{Id: oidExtKeyUsage, Value: b}}, nil
+}
+
+// check returns an error if crt lacks any of the usages selected with e.
+// CAs are free to ignore the requested usages, so the caller should only
+// warn about it.
+func (e ekuFlag) check(crt *x509.Certificate) error {
+	have := make(map[x509.ExtKeyUsage]bool, len(crt.ExtKeyUsage))
+	for _, u := range crt.ExtKeyUsage {
+		have[u] = true
+	}
+	for _, u := range e.usages() {
+		if !have[u] && !have[x509.ExtKeyUsageAny] {
+			return fmt.Errorf("certificate lacks the requested %s key usage", ekuName(u))
+		}
+	}
+	return nil
+}
+
+func ekuName(u x509.ExtKeyUsage) string {
+	switch u {
+	case x509.ExtKeyUsageServerAuth:
+		return "server"
+	case x509.ExtKeyUsageClientAuth:
+		return "client"
+	}
+	return fmt.Sprintf("%d", u)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+)
+
+// encryptedPrivateKey is the PEM block type of a PKCS#8 encrypted private key.
+const encryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+// encryptedMeta is the PEM block type of encrypted certificate metadata.
+const encryptedMeta = "ACME ENCRYPTED METADATA"
+
+// pbkdf2Iter is the PBKDF2 iteration count used when encrypting.
+const pbkdf2Iter = 600000
+
+// errPassphrase is returned when decryption fails, most likely
+// because of a wrong passphrase.
+var errPassphrase = errors.New("decryption failed; wrong passphrase?")
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure.
+type encryptedPrivateKeyInfo struct {
+	Algo pkix.AlgorithmIdentifier
+	Data []byte
+}
+
+// pbes2Params are the RFC 8018 PBES2 parameters.
+type pbes2Params struct {
+	KDF    pkix.AlgorithmIdentifier
+	Scheme pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the RFC 8018 PBKDF2 parameters.
+type pbkdf2Params struct {
+	Salt   []byte
+	Iter   int
+	KeyLen int                      `asn1:"optional"`
+	PRF    pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// isEncryptedBlock reports whether d is an encrypted private key,
+// either PKCS#8 or a legacy RFC 1423 block written by earlier versions.
+func isEncryptedBlock(d *pem.Block) bool {
+	return d.Type == encryptedPrivateKey || x509.IsEncryptedPEMBlock(d)
+}
+
+// encryptPKCS8 encrypts the PKCS#8 encoded private key der with pass,
+// using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, as defined
+// by RFC 8018. This is the format OpenSSL and other tools write
+// for ENCRYPTED PRIVATE KEY blocks.
+func encryptPKCS8(der, pass []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2Key(pass, salt, pbkdf2Iter, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	n := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte{}, der...), make([]byte, n)...)
+	for i := len(der); i < len(data); i++ {
+		data[i] = byte(n)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt: salt,
+		Iter: pbkdf2Iter,
+		PRF:  pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KDF:    pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		Scheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data: data,
+	})
+}
+
+// decryptPKCS8 decrypts the PKCS#8 EncryptedPrivateKeyInfo der with pass,
+// returning the PKCS#8 encoded private key. Only PBES2 with PBKDF2 and
+// AES-CBC is supported, which covers keys written by encryptPKCS8 and
+// by current OpenSSL versions.
+func decryptPKCS8(der, pass []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption %v; only PBES2 is supported", info.Algo.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("PBES2 parameters: %v", err)
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %v; only PBKDF2 is supported", params.KDF.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("PBKDF2 parameters: %v", err)
+	}
+	var h func() hash.Hash
+	switch prf := kdf.PRF.Algorithm; {
+	case len(prf) == 0 || prf.Equal(oidHMACSHA1):
+		h = sha1.New
+	case prf.Equal(oidHMACSHA256):
+		h = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 function %v", prf)
+	}
+	var keyLen int
+	switch alg := params.Scheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		keyLen = 16
+	case alg.Equal(oidAES192CBC):
+		keyLen = 24
+	case alg.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %v; only AES-CBC is supported", alg)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Scheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("malformed AES-CBC parameters")
+	}
+	if kdf.Iter < 1 || len(info.Data) == 0 || len(info.Data)%aes.BlockSize != 0 {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	block, err := aes.NewCipher(pbkdf2Key(pass, kdf.Salt, kdf.Iter, keyLen, h))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, info.Data)
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize {
+		return nil, errPassphrase
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errPassphrase
+		}
+	}
+	data = data[:len(data)-n]
+	// a wrong passphrase may still give valid padding by chance
+	var v asn1.RawValue
+	if rest, err := asn1.Unmarshal(data, &v); err != nil || len(rest) > 0 {
+		return nil, errPassphrase
+	}
+	return data, nil
+}
+
+// pbkdf2Key derives a keyLen bytes long key from pass and salt
+// with PBKDF2, as defined by RFC 8018, using HMAC with h.
+func pbkdf2Key(pass, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, pass)
+	size := prf.Size()
+	var key []byte
+	u := make([]byte, size)
+	for i := uint32(1); len(key) < keyLen; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, i)
+		key = prf.Sum(key)
+		t := key[len(key)-size:]
+		copy(u, t)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return key[:keyLen]
+}
+
+// metaKeyParams are the inputs of a derived metadata encryption key.
+type metaKeyParams struct {
+	pass, salt string
+	iter       int
+}
+
+// metaKeys caches derived metadata encryption keys, since deriving them
+// is deliberately slow and a run reads and writes metadata many times.
+var metaKeys = make(map[metaKeyParams][]byte)
+
+// metaKey returns the metadata encryption key for salt and iter,
+// derived from the keyPassEnv passphrase.
+func metaKey(salt []byte, iter int) ([]byte, error) {
+	pass := os.Getenv(keyPassEnv)
+	if pass == "" {
+		return nil, fmt.Errorf("set %s to the passphrase", keyPassEnv)
+	}
+	p := metaKeyParams{pass: pass, salt: string(salt), iter: iter}
+	if k, ok := metaKeys[p]; ok {
+		return k, nil
+	}
+	k := pbkdf2Key([]byte(pass), salt, iter, 32, sha256.New)
+	metaKeys[p] = k
+	return k, nil
+}
+
+// encryptMeta returns the PEM encoded encryption of b with AES-256-GCM,
+// keyed with the keyPassEnv passphrase. The block holds the PBKDF2 salt,
+// followed by the GCM nonce and the sealed b.
+func encryptMeta(b []byte) ([]byte, error) {
+	// reuse the salt of a cached key to avoid deriving another one;
+	// nonces are random, so a key may encrypt many files
+	var salt []byte
+	for p := range metaKeys {
+		if p.pass == os.Getenv(keyPassEnv) && p.iter == pbkdf2Iter {
+			salt = []byte(p.salt)
+			break
+		}
+	}
+	if salt == nil {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	aead, err := metaAEAD(salt, pbkdf2Iter)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data := append(salt[:len(salt):len(salt)], nonce...)
+	data = aead.Seal(data, nonce, b, nil)
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    encryptedMeta,
+		Headers: map[string]string{"Iterations": strconv.Itoa(pbkdf2Iter)},
+		Bytes:   data,
+	}), nil
+}
+
+// decryptMeta decrypts the metadata block d created by encryptMeta.
+func decryptMeta(d *pem.Block) ([]byte, error) {
+	iter, err := strconv.Atoi(d.Headers["Iterations"])
+	if err != nil || iter < 1 || len(d.Bytes) < 16 {
+		return nil, errors.New("malformed encrypted metadata")
+	}
+	aead, err := metaAEAD(d.Bytes[:16], iter)
+	if err != nil {
+		return nil, err
+	}
+	data := d.Bytes[16:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted metadata")
+	}
+	b, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errPassphrase
+	}
+	return b, nil
+}
+
+func metaAEAD(salt []byte, iter int) (cipher.AEAD, error) {
+	k, err := metaKey(salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 6070 test vectors
+	for _, test := range []struct {
+		iter int
+		want string
+	}{
+		{1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{4096, "4b007901b765489abead49d926f721d065a429c1"},
+	} {
+		k := pbkdf2Key([]byte("password"), []byte("salt"), test.iter, 20, sha1.New)
+		if got := hex.EncodeToString(k); got != test.want {
+			t.Errorf("%d iterations: %s; want %s", test.iter, got, test.want)
+		}
+	}
+	k := pbkdf2Key([]byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25, sha1.New)
+	if got, want := hex.EncodeToString(k), "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"; got != want {
+		t.Errorf("long key: %s; want %s", got, want)
+	}
+}
+
+func TestEncryptPKCS8(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := encryptPKCS8(der, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(enc), string(der)) {
+		t.Fatal("encrypted key contains the plain key")
+	}
+	b, err := decryptPKCS8(enc, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(der) {
+		t.Error("decrypted key differs")
+	}
+	if _, err := decryptPKCS8(enc, []byte("wrong")); err != errPassphrase {
+		t.Errorf("wrong passphrase: err = %v; want %v", err, errPassphrase)
+	}
+}
+
+func TestEncryptMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(keyPassEnv, os.Getenv(keyPassEnv))
+	defer func(e bool) { certEncrypt = e }(certEncrypt)
+	os.Setenv(keyPassEnv, "secret")
+
+	certPath := filepath.Join(dir, "example.org.crt")
+	certEncrypt = true
+	if err := writeMeta(certPath, &certMeta{Issuer: "Example CA"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(metaPath(certPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := pem.Decode(b); d == nil || d.Type != encryptedMeta || strings.Contains(string(b), "Example CA") {
+		t.Fatalf("metadata is not encrypted:\n%s", b)
+	}
+
+	// encrypted metadata stays encrypted without -encrypt
+	certEncrypt = false
+	m, err := readMeta(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Issuer != "Example CA" {
+		t.Errorf("m.Issuer = %q; want %q", m.Issuer, "Example CA")
+	}
+	m.Deployed = "hash"
+	if err := writeMeta(certPath, m); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(metaPath(certPath)); strings.Contains(string(b), "hash") {
+		t.Error("rewritten metadata is not encrypted")
+	}
+
+	os.Setenv(keyPassEnv, "wrong")
+	if _, err := readMeta(certPath); err == nil {
+		t.Error("readMeta with wrong passphrase: nil error")
+	}
+	os.Setenv(keyPassEnv, "")
+	if _, err := readMeta(certPath); err == nil || !strings.Contains(err.Error(), keyPassEnv) {
+		t.Errorf("readMeta with no passphrase: err = %v; want it to name %s", err, keyPassEnv)
+	}
+}
+
+func TestWriteOutDirDecrypts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-outdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(keyPassEnv, os.Getenv(keyPassEnv))
+	os.Setenv(keyPassEnv, "secret")
+
+	keyPath := filepath.Join(dir, "example.org.key")
+	k, err := anyKey(keyPath, true, keySpec{Type: "ec", Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutDir(filepath.Join(dir, "out"), "example.org", [][]byte{[]byte("cert")}, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out", "example.org", "privkey.pem")
+	if enc, err := isEncryptedKey(out); err != nil || enc {
+		t.Fatalf("out dir key: encrypted = %v, %v", enc, err)
+	}
+	os.Setenv(keyPassEnv, "")
+	read, err := readKey(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(read, k) {
+		t.Error("out dir key differs")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdGenkey = &command{
+		run:       runGenkey,
+		UsageLine: "genkey [-c config] [-keytype rsa|ec] [-rsabits n] [-key-format pkcs1|pkcs8] [file]",
+		Short:     "generate an account key without registering",
+		Long: `
+Genkey generates a new private key and prints its JWK thumbprint,
+without contacting a CA. The key can later be used with reg,
+or loaded into a key store before registering.
+
+The key is written to the file argument in PEM format.
+If not specified, it is written to {{.AccountKey}} in the config dir.
+An existing file is never overwritten.
+
+The -keytype flag selects an RSA or ECDSA P-256 key. The default is rsa,
+the account key type CAs most widely accept. It is independent of the
+type of certificate keys; see acme help cert.
+The -rsabits flag specifies the RSA key size, 2048 bits by default.
+The -key-format flag has the same meaning as for the cert command.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	genkeyType = defaultKeySpec.Type
+	genkeyBits = defaultKeySpec.Bits
+)
+
+func init() {
+	cmdGenkey.flag.StringVar(&genkeyType, "keytype", genkeyType, "")
+	cmdGenkey.flag.IntVar(&genkeyBits, "rsabits", genkeyBits, "")
+	cmdGenkey.flag.Var(&keyFormat, "key-format", "")
+}
+
+func runGenkey(args []string) {
+	if len(args) > 1 {
+		fatalf("too many arguments")
+	}
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		if err := checkConfigDir(); err != nil {
+			fatalf("%v", err)
+		}
+		path = filepath.Join(configDir, configKeyFile)
+	}
+	if _, err := os.Stat(path); err == nil {
+		fatalf("%s already exists", path)
+	}
+
+	spec := keySpec{Type: genkeyType}
+	if genkeyType == "rsa" {
+		spec.Bits = genkeyBits
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fatalf("%v", err)
+	}
+	key, err := anyKey(path, true, accountKeySpec(spec))
+	if err != nil {
+		fatalf("genkey: %v", err)
+	}
+	thumb, err := acme.JWKThumbprint(key.Public())
+	if err != nil {
+		fatalf("%v", err)
+	}
+	logf("key written to %s", path)
+	fmt.Println(thumb)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	cmdHash = &command{
+		run:       runHash,
+		UsageLine: "hash [-k key] file",
+		Short:     "print the hash of a certificate and its key",
+		Long: `
+Hash prints the hex encoded SHA-256 of the certificate chain file
+followed by its key file. It is the same value the cert command
+records after writing a certificate, and can be used to detect whether
+a deployed certificate and key changed.
+
+The key file is expected alongside the certificate, with the .crt
+extension replaced by .key, unless specified with -k argument.
+`,
+	}
+
+	hashKeypath string
+)
+
+func init() {
+	cmdHash.flag.StringVar(&hashKeypath, "k", "", "")
+}
+
+func runHash(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one certificate file")
+	}
+	key := hashKeypath
+	if key == "" {
+		key = strings.TrimSuffix(args[0], ".crt") + ".key"
+	}
+	h, err := bundleHash(args[0], key)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Println(h)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// runHook runs the shell command cmd with env added to the environment.
+// The command output goes to the standard output and error.
+func runHook(cmd string, env ...string) error {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", cmd)
+	} else {
+		c = exec.Command("sh", "-c", cmd)
+	}
+	c.Env = append(os.Environ(), env...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	cmdInfo = &command{
+		run:       runInfo,
+		UsageLine: "info [-json] [-max-cert-lifetime dur] file [file ...]",
+		Short:     "display certificate details",
+		Long: `
+Info displays details of the certificate found in the PEM file,
+such as the certified names, validity period and the Certificate
+Transparency signed certificate timestamps (SCTs) embedded in it,
+and its CRL distribution points. See also acme help crl-check.
+
+Only SCTs embedded in the certificate are shown. SCTs delivered
+with OCSP responses or in the TLS handshake are not visible here.
+
+A warning is shown if the total validity period of the certificate
+exceeds -max-cert-lifetime, which is {{.MaxCertLifetime}} days by default,
+as some clients reject certificates valid for longer.
+
+Each argument may also be a directory or a glob pattern, such as
+'/etc/ssl/certs/*.pem'. If more than one file is given, a summary table
+of the first certificate in each file is shown instead of the details.
+Files not containing a certificate are skipped with a warning.
+
+A warning is printed if the certificate is not valid yet, or if its
+validity period starts more than a day before the earliest embedded SCT,
+which indicates backdating by the CA.
+
+The -json flag makes the output a JSON object, or an array of objects
+for multiple files, instead. Without it, the fields are aligned when output
+to a terminal, and printed as unaligned "name: value" lines otherwise.
+`,
+	}
+
+	infoJSON        bool
+	infoMaxLifetime = 398 * 24 * time.Hour
+)
+
+func init() {
+	cmdInfo.flag.BoolVar(&infoJSON, "json", infoJSON, "")
+	cmdInfo.flag.DurationVar(&infoMaxLifetime, "max-cert-lifetime", infoMaxLifetime, "")
+}
+
+func runInfo(args []string) {
+	if len(args) == 0 {
+		fatalf("no certificate file specified")
+	}
+	files, err := expandPaths(args)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(files) == 1 && len(args) == 1 && files[0] == args[0] {
+		crt, err := readCrt(files[0])
+		if err != nil {
+			fatalf("%v", err)
+		}
+		ci, err := newCertInfo(crt)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if infoJSON {
+			printJSON(ci)
+			return
+		}
+		printCert(os.Stdout, ci)
+		return
+	}
+
+	var list []*certInfo
+	for _, f := range files {
+		crts, err := readCerts(f)
+		if err != nil {
+			logf("skipping %s: %v", f, err)
+			continue
+		}
+		ci, err := newCertInfo(crts[0])
+		if err != nil {
+			logf("skipping %s: %v", f, err)
+			continue
+		}
+		ci.File = f
+		list = append(list, ci)
+	}
+	if infoJSON {
+		printJSON(list)
+		return
+	}
+	printCertTable(os.Stdout, list)
+}
+
+// expandPaths returns the files matching args, each of which is either
+// a file name, a directory whose files are all included, or a glob pattern.
+func expandPaths(args []string) ([]string, error) {
+	var res []string
+	for _, a := range args {
+		if fi, err := os.Stat(a); err == nil {
+			if !fi.IsDir() {
+				res = append(res, a)
+				continue
+			}
+			m, err := filepath.Glob(filepath.Join(a, "*"))
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range m {
+				if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+					res = append(res, p)
+				}
+			}
+			continue
+		}
+		m, err := filepath.Glob(a)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) == 0 {
+			return nil, fmt.Errorf("%s: no such file", a)
+		}
+		res = append(res, m...)
+	}
+	return res, nil
+}
+
+// printJSON outputs v to the standard output in indented JSON format.
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("%s\n", b)
+}
+
+// printCertTable outputs a table summarizing certificates in list
+// into w using tabwriter.
+func printCertTable(w io.Writer, list []*certInfo) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSUBJECT\tISSUER\tNOT AFTER\tDAYS LEFT")
+	for _, ci := range list {
+		left := days(ci.NotAfter.Sub(time.Now()))
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n",
+			ci.File, ci.Subject, ci.Issuer, ci.NotAfter.Format(time.RFC3339), left)
+	}
+	tw.Flush()
+}
+
+// certInfo is a summary of a certificate.
+type certInfo struct {
+	File      string    `json:"file,omitempty"`
+	Subject   string    `json:"subject"`
+	Names     []string  `json:"names"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Days      int       `json:"validityDays"` // total validity period
+	SCTs      []sct     `json:"scts"`
+	CRLs      []string  `json:"crlDistributionPoints"`
+}
+
+// sct is a signed certificate timestamp, as defined in RFC 6962.
+type sct struct {
+	LogID     string    `json:"logID"` // base64 encoded
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newCertInfo(crt *x509.Certificate) (*certInfo, error) {
+	scts, err := certSCTs(crt)
+	if err != nil {
+		return nil, err
+	}
+	return &certInfo{
+		Subject:   crt.Subject.CommonName,
+		Names:     crt.DNSNames,
+		Issuer:    crt.Issuer.CommonName,
+		Serial:    fmt.Sprintf("%X", crt.SerialNumber),
+		NotBefore: crt.NotBefore,
+		NotAfter:  crt.NotAfter,
+		Days:      days(crt.NotAfter.Sub(crt.NotBefore)),
+		SCTs:      scts,
+		CRLs:      crt.CRLDistributionPoints,
+	}, nil
+}
+
+// printCert outputs certificate info into w using fieldWriter.
+func printCert(w io.Writer, ci *certInfo) {
+	tw := fieldWriter(w)
+	fmt.Fprintln(tw, "Subject:\t", ci.Subject)
+	fmt.Fprintln(tw, "Names:\t", strings.Join(ci.Names, ", "))
+	fmt.Fprintln(tw, "Issuer:\t", ci.Issuer)
+	fmt.Fprintln(tw, "Serial:\t", ci.Serial)
+	fmt.Fprintln(tw, "Not before:\t", ci.NotBefore.Format(time.RFC3339))
+	fmt.Fprintln(tw, "Not after:\t", ci.NotAfter.Format(time.RFC3339))
+	fmt.Fprintln(tw, "Validity:\t", ci.Days, "days")
+	if ci.NotAfter.Sub(ci.NotBefore) > infoMaxLifetime {
+		fmt.Fprintln(tw, "Warning:\t", "validity exceeds", days(infoMaxLifetime), "days")
+	}
+	if s := notBeforeWarning(ci, time.Now()); s != "" {
+		fmt.Fprintln(tw, "Warning:\t", s)
+	}
+	if len(ci.SCTs) == 0 {
+		fmt.Fprintln(tw, "SCTs:\t", "none embedded")
+	}
+	for _, s := range ci.SCTs {
+		fmt.Fprintln(tw, "SCT:\t", s.LogID, s.Timestamp.Format(time.RFC3339))
+	}
+	if len(ci.CRLs) == 0 {
+		fmt.Fprintln(tw, "CRL:\t", "no distribution point")
+	}
+	for _, u := range ci.CRLs {
+		fmt.Fprintln(tw, "CRL:\t", u)
+	}
+	tw.Flush()
+}
+
+// maxBackdate is how long before its earliest SCT a certificate
+// may become valid without being reported as backdated.
+const maxBackdate = 24 * time.Hour
+
+// notBeforeWarning returns a warning about the start of the validity period
+// of ci, or an empty string. A certificate valid only after now is
+// not accepted by TLS clients yet. A certificate valid long before
+// it was logged, as told by its SCTs, has been backdated by the CA.
+func notBeforeWarning(ci *certInfo, now time.Time) string {
+	if d := ci.NotBefore.Sub(now); d > 0 {
+		h := (d + time.Hour - 1) / time.Hour
+		return fmt.Sprintf("not valid yet, valid in %d hours", h)
+	}
+	var issued time.Time
+	for _, s := range ci.SCTs {
+		if issued.IsZero() || s.Timestamp.Before(issued) {
+			issued = s.Timestamp
+		}
+	}
+	if d := issued.Sub(ci.NotBefore); !issued.IsZero() && d > maxBackdate {
+		return fmt.Sprintf("backdated by %d hours before issuance", d/time.Hour)
+	}
+	return ""
+}
+
+// days returns d in whole days, rounded up.
+func days(d time.Duration) int {
+	day := 24 * time.Hour
+	return int((d + day - 1) / day)
+}
+
+// oidSCTList is the X.509v3 extension holding embedded SCTs.
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+var errBadSCTList = errors.New("malformed SCT list extension")
+
+// certSCTs parses the SCT list embedded in crt, if any.
+func certSCTs(crt *x509.Certificate) ([]sct, error) {
+	var ext []byte
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(oidSCTList) {
+			ext = e.Value
+			break
+		}
+	}
+	if ext == nil {
+		return nil, nil
+	}
+	// the TLS encoded list is wrapped in an OCTET STRING
+	var b []byte
+	if rest, err := asn1.Unmarshal(ext, &b); err != nil || len(rest) > 0 {
+		return nil, errBadSCTList
+	}
+	list, b, ok := tlsVector(b)
+	if !ok || len(b) > 0 {
+		return nil, errBadSCTList
+	}
+	var res []sct
+	for len(list) > 0 {
+		var v []byte
+		if v, list, ok = tlsVector(list); !ok {
+			return nil, errBadSCTList
+		}
+		// version (1 byte), log ID (32 bytes), timestamp (8 bytes)
+		if len(v) < 41 || v[0] != 0 {
+			return nil, errBadSCTList
+		}
+		ms := int64(binary.BigEndian.Uint64(v[33:41]))
+		res = append(res, sct{
+			LogID:     base64.StdEncoding.EncodeToString(v[1:33]),
+			Timestamp: time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC(),
+		})
+	}
+	return res, nil
+}
+
+// tlsVector splits b into a vector with a 2 byte length prefix
+// and the remaining bytes.
+func tlsVector(b []byte) (v, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
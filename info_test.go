@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate created from tmpl.
+func testCert(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.SerialNumber == nil {
+		tmpl.SerialNumber = big.NewInt(1)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func TestCertSCTs(t *testing.T) {
+	ts := time.Date(2017, 3, 1, 12, 0, 0, 500*int(time.Millisecond), time.UTC)
+	logID := make([]byte, 32)
+	logID[0] = 0xab
+
+	// a single v1 SCT with no extensions and a dummy signature
+	v := []byte{0}
+	v = append(v, logID...)
+	v = append(v, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(v[33:], uint64(ts.UnixNano()/int64(time.Millisecond)))
+	v = append(v, 0, 0)             // extensions
+	v = append(v, 4, 3, 0, 1, 0xff) // signature
+	list := append([]byte{0, byte(len(v))}, v...)
+	list = append([]byte{0, byte(len(list))}, list...)
+	ext, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt := testCert(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "example.org"},
+		NotBefore:       ts,
+		NotAfter:        ts.Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidSCTList, Value: ext}},
+	})
+	scts, err := certSCTs(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 1 {
+		t.Fatalf("len(scts) = %d; want 1", len(scts))
+	}
+	if id := base64.StdEncoding.EncodeToString(logID); scts[0].LogID != id {
+		t.Errorf("LogID = %q; want %q", scts[0].LogID, id)
+	}
+	if !scts[0].Timestamp.Equal(ts) {
+		t.Errorf("Timestamp = %v; want %v", scts[0].Timestamp, ts)
+	}
+
+	crt = testCert(t, &x509.Certificate{NotBefore: ts, NotAfter: ts.Add(time.Hour)})
+	if scts, err := certSCTs(crt); err != nil || scts != nil {
+		t.Errorf("certSCTs without extension = %v, %v; want nil, nil", scts, err)
+	}
+}
+
+func TestNotBeforeWarning(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ci   certInfo
+		want string
+	}{
+		{certInfo{NotBefore: now.Add(-time.Hour)}, ""},
+		{certInfo{NotBefore: now.Add(90 * time.Minute)}, "not valid yet, valid in 2 hours"},
+		{certInfo{
+			NotBefore: now.Add(-72 * time.Hour),
+			SCTs:      []sct{{Timestamp: now.Add(-time.Hour)}, {Timestamp: now.Add(-24 * time.Hour)}},
+		}, "backdated by 48 hours before issuance"},
+		{certInfo{
+			NotBefore: now.Add(-2 * time.Hour),
+			SCTs:      []sct{{Timestamp: now.Add(-time.Hour)}},
+		}, ""},
+	}
+	for i, test := range tests {
+		if got := notBeforeWarning(&test.ci, now); got != test.want {
+			t.Errorf("%d: notBeforeWarning = %q; want %q", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdInit = &command{
+		run:       runInit,
+		UsageLine: "init [-c config] [-d url] [-email addr] [-keytype rsa|ec] [-accept]",
+		Short:     "guided account setup",
+		Long: `
+Init sets up a new account, asking for the choices otherwise made
+with reg and genkey flags: the CA, a contact email address, the account
+key type and acceptance of the CA Terms of Service.
+
+Each question is skipped if the answer is given with a flag:
+-d for the CA discovery URL or alias, -email for the contact address,
+-keytype for the key type and -accept for the Terms of Service.
+The -email flag may be set to an empty value to register without contact.
+When all of them are specified, init runs without prompting
+and is equivalent to genkey followed by reg.
+
+If the account key already exists in the config dir, it is used
+instead of generating a new one.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	initDisco   discoAliasFlag
+	initEmail   string
+	initKeyType string
+	initAccept  bool
+	initFlags   *flag.FlagSet // cmdInit.flag, to tell which flags are set
+)
+
+func init() {
+	initFlags = &cmdInit.flag
+	cmdInit.flag.Var(&initDisco, "d", "")
+	cmdInit.flag.StringVar(&initEmail, "email", "", "")
+	cmdInit.flag.StringVar(&initKeyType, "keytype", "", "")
+	cmdInit.flag.BoolVar(&initAccept, "accept", initAccept, "")
+}
+
+func runInit(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	set := setFlags(initFlags)
+	in := bufio.NewReader(os.Stdin)
+	ask := func(q, def string) string {
+		if !isTerminal(os.Stdin) {
+			fatalf("%s: no answer given with flags and stdin is not a terminal", q)
+		}
+		fmt.Printf("%s [%s]: ", q, def)
+		a, err := in.ReadString('\n')
+		if err != nil {
+			fatalf("%s: no answer: %v", q, err)
+		}
+		if a = strings.TrimSpace(a); a == "" {
+			return def
+		}
+		return a
+	}
+
+	if !set["d"] {
+		var names []string
+		for k := range discoAliases {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		fmt.Printf("Known CAs: %s.\n", strings.Join(names, ", "))
+		if err := initDisco.Set(ask("CA alias or discovery URL", defaultDisco)); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if !set["email"] {
+		initEmail = ask("Contact email, or - for none", "-")
+		if initEmail == "-" {
+			initEmail = ""
+		}
+	}
+	keyPath := filepath.Join(configDir, configKeyFile)
+	_, err := os.Stat(keyPath)
+	exists := err == nil
+	if !exists && !set["keytype"] {
+		initKeyType = ask("Account key type, rsa or ec", defaultKeySpec.Type)
+	}
+
+	if cur, err := readConfigFile(); err == nil && cur.URI != "" {
+		if cur.CA == string(initDisco) || cur.Accounts[string(initDisco)] != nil {
+			fatalf("an account at %s already exists in %s", initDisco, configDir)
+		}
+	}
+
+	spec := defaultKeySpec
+	if initKeyType != "" && initKeyType != spec.Type {
+		spec = keySpec{Type: initKeyType}
+	}
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		fatalf("%v", err)
+	}
+	key, err := anyKey(keyPath, true, accountKeySpec(spec))
+	if err != nil {
+		fatalf("account key: %v", err)
+	}
+	if exists {
+		logf("using existing account key %s", keyPath)
+	} else {
+		logf("account key written to %s", keyPath)
+	}
+
+	uc := &userConfig{CA: string(initDisco), key: key}
+	if initEmail != "" {
+		uc.Contact = []string{"mailto:" + initEmail}
+	}
+	prompt := ttyPrompt
+	if initAccept {
+		prompt = acme.AcceptTOS
+	} else if !isTerminal(os.Stdin) {
+		prompt = func(tos string) bool {
+			logf("CA requires acceptance of %s; use -accept", tos)
+			return false
+		}
+	}
+	if err := register(uc, prompt); err != nil {
+		fatalf("%v", err)
+	}
+	printAccount(os.Stdout, &uc.Account, uc.keyPath())
+}
+
+// isTerminal reports whether f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
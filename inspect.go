@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	cmdInspect = &command{
+		run:       runInspect,
+		UsageLine: "inspect file",
+		Short:     "describe the contents of a PEM file",
+		Long: `
+Inspect describes each PEM block in file, whatever its type, so any
+certificate, request or key in the config dir can be examined with
+one command.
+
+Certificates are described as by the info command, certificate requests
+by their subject, names and key, and private or public keys by their type
+and fingerprints, as printed by the trust command. Encrypted keys are
+decrypted with {{.KeyPassEnv}} environment variable, if set.
+Blocks of other types are listed with their size.
+`,
+	}
+)
+
+func runInspect(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one file")
+	}
+	b, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	n := 0
+	for {
+		var d *pem.Block
+		if d, b = pem.Decode(b); d == nil {
+			break
+		}
+		if n > 0 {
+			fmt.Println()
+		}
+		n++
+		fmt.Printf("Block %d: %s\n", n, d.Type)
+		if err := inspectBlock(os.Stdout, args[0], d); err != nil {
+			errorf("block %d: %v", n, err)
+		}
+	}
+	if n == 0 {
+		fatalf("no PEM block found in %s", args[0])
+	}
+}
+
+// inspectBlock describes the PEM block d, read from path, into w.
+func inspectBlock(w io.Writer, path string, d *pem.Block) error {
+	switch d.Type {
+	case x509PublicKey:
+		crt, err := x509.ParseCertificate(d.Bytes)
+		if err != nil {
+			return err
+		}
+		ci, err := newCertInfo(crt)
+		if err != nil {
+			return err
+		}
+		printCert(w, ci)
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		csr, err := x509.ParseCertificateRequest(d.Bytes)
+		if err != nil {
+			return err
+		}
+		printCSR(w, csr)
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(d.Bytes)
+		if err != nil {
+			return err
+		}
+		return printKey(w, pub, path)
+	case rsaPrivateKey, ecPrivateKey, pkcs8PrivateKey, encryptedPrivateKey:
+		if isEncryptedBlock(d) {
+			var err error
+			if d, err = decryptKey(path, d); err != nil {
+				return err
+			}
+		}
+		k, err := parseKey(d)
+		if err != nil {
+			return err
+		}
+		return printKey(w, k.Public(), path)
+	default:
+		fmt.Fprintf(w, "Unknown block type, %d bytes of base64 data\n", base64.StdEncoding.EncodedLen(len(d.Bytes)))
+	}
+	return nil
+}
+
+// printCSR outputs the certificate request csr into w using fieldWriter.
+func printCSR(w io.Writer, csr *x509.CertificateRequest) {
+	tw := fieldWriter(w)
+	fmt.Fprintln(tw, "Subject:\t", csr.Subject.CommonName)
+	fmt.Fprintln(tw, "Names:\t", strings.Join(csr.DNSNames, ", "))
+	fmt.Fprintln(tw, "Key:\t", keyDesc(csr.PublicKey))
+	fmt.Fprintln(tw, "Signature:\t", csr.SignatureAlgorithm)
+	if err := csr.CheckSignature(); err != nil {
+		fmt.Fprintln(tw, "Warning:\t", err)
+	}
+	tw.Flush()
+}
+
+// printKey outputs the type of the public key pub, read from path,
+// followed by its fingerprints, into w.
+func printKey(w io.Writer, pub crypto.PublicKey, path string) error {
+	tw := fieldWriter(w)
+	fmt.Fprintln(tw, "Type:\t", keyDesc(pub))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printFingerprints(w, pub, path)
+}
+
+// keyDesc returns a short description of the type and size of pub,
+// such as "RSA 2048 bit" or "ECDSA P-256".
+func keyDesc(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bit", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
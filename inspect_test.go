@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestInspectBlock(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.org"},
+		DNSNames: []string{"example.org", "www.example.org"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := keyPEM(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		block *pem.Block
+		want  []string
+	}{
+		{&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}, []string{"Names: example.org, www.example.org", "Key: ECDSA P-384"}},
+		{keyBlock, []string{"Type: ECDSA P-384", "Key: example.org.key", "SPKI: sha256/"}},
+		{&pem.Block{Type: "DH PARAMETERS", Bytes: make([]byte, 3)}, []string{"4 bytes of base64"}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := inspectBlock(&buf, "example.org.key", test.block); err != nil {
+			t.Errorf("%s: %v", test.block.Type, err)
+			continue
+		}
+		for _, s := range test.want {
+			if !strings.Contains(buf.String(), s) {
+				t.Errorf("%s: output does not contain %q:\n%s", test.block.Type, s, buf.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalFile is the name of the challenge journal in configDir.
+const journalFile = "challenges.json"
+
+// journalEntry is a challenge artifact created during a run,
+// recorded until it is cleaned up.
+type journalEntry struct {
+	// Type is "file" for a challenge response file
+	// or "dns" for a manually added TXT record.
+	Type   string    `json:"type"`
+	Domain string    `json:"domain"`
+	Path   string    `json:"path,omitempty"`   // for "file"
+	Record string    `json:"record,omitempty"` // for "dns", the TXT record name
+	Value  string    `json:"value,omitempty"`  // for "dns"
+	Time   time.Time `json:"time"`
+}
+
+// readJournal returns the entries recorded in the journal.
+// A missing journal has no entries.
+func readJournal() ([]journalEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(configDir, journalFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []journalEntry
+	return list, json.Unmarshal(b, &list)
+}
+
+// writeJournal replaces the journal entries with list,
+// removing the journal if list is empty.
+func writeJournal(list []journalEntry) error {
+	path := filepath.Join(configDir, journalFile)
+	if len(list) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, 0600)
+}
+
+// journalAdd records e in the journal.
+// Failures are only logged, since the journal is a recovery aid.
+func journalAdd(e journalEntry) {
+	if configDir == "" {
+		return
+	}
+	e.Time = time.Now()
+	list, err := readJournal()
+	if err == nil {
+		err = writeJournal(append(list, e))
+	}
+	if err != nil {
+		logf("challenge journal: %v", err)
+	}
+}
+
+// journalRemove removes entries matching e by type, path and record
+// from the journal, once the artifact is cleaned up.
+func journalRemove(e journalEntry) {
+	if configDir == "" {
+		return
+	}
+	list, err := readJournal()
+	if err != nil {
+		logf("challenge journal: %v", err)
+		return
+	}
+	res := list[:0]
+	for _, x := range list {
+		if x.Type != e.Type || x.Path != e.Path || x.Record != e.Record {
+			res = append(res, x)
+		}
+	}
+	if len(res) == len(list) {
+		return
+	}
+	if err := writeJournal(res); err != nil {
+		logf("challenge journal: %v", err)
+	}
+}
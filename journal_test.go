@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { configDir = d }(configDir)
+	configDir = dir
+
+	a := journalEntry{Type: "file", Domain: "example.org", Path: "/tmp/a"}
+	b := journalEntry{Type: "dns", Domain: "example.org", Record: "_acme-challenge.example.org", Value: "x"}
+	journalAdd(a)
+	journalAdd(b)
+	list, err := readJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Path != a.Path || list[1].Record != b.Record {
+		t.Fatalf("readJournal = %+v; want entries %+v and %+v", list, a, b)
+	}
+
+	journalRemove(a)
+	journalRemove(b)
+	if list, err := readJournal(); err != nil || len(list) != 0 {
+		t.Errorf("readJournal after remove = %+v, %v; want no entries", list, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); !os.IsNotExist(err) {
+		t.Errorf("empty journal not removed: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+var (
+	cmdExportJWK = &command{
+		run:       runExportJWK,
+		UsageLine: "export-jwk [-c config] [file]",
+		Short:     "export the account key as a JWK",
+		Long: `
+Export-jwk writes the account key of the account selected with -ca
+as a JSON Web Key, as defined by RFC 7517, to file or to the standard
+output if file is not specified. The "kid" member is set to the account
+URI, so that tools reading JWK account files can use the account
+without registering again. RSA and ECDSA keys are supported.
+
+The JWK contains the private key. A file is created with 0600 mode.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+)
+
+func runExportJWK(args []string) {
+	if len(args) > 1 {
+		fatalf("too many arguments")
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	if uc.URI == "" {
+		logf("warning: the account is not registered; the JWK has no key ID")
+	}
+	jwk, err := privateJWK(uc.key, uc.URI)
+	if err != nil {
+		fatalf("%s: %v", uc.keyPath(), err)
+	}
+	b, err := json.MarshalIndent(jwk, "", "  ")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	b = append(b, '\n')
+	if len(args) == 0 {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := writeFileAtomic(args[0], b, 0600); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// jsonWebKey is a private JWK, as defined by RFC 7517 and RFC 7518.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	Dp string `json:"dp,omitempty"`
+	Dq string `json:"dq,omitempty"`
+	Qi string `json:"qi,omitempty"`
+
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	D string `json:"d"`
+}
+
+// privateJWK returns the JWK of the RSA or ECDSA private key k,
+// with the key ID kid and the JWS algorithm used to sign ACME requests.
+func privateJWK(k crypto.Signer, kid string) (*jsonWebKey, error) {
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("RSA key with %d primes cannot be exported", len(k.Primes))
+		}
+		k.Precompute()
+		return &jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: "RS256",
+			N:   b64(k.N.Bytes()),
+			E:   b64(big.NewInt(int64(k.E)).Bytes()),
+			D:   b64(k.D.Bytes()),
+			P:   b64(k.Primes[0].Bytes()),
+			Q:   b64(k.Primes[1].Bytes()),
+			Dp:  b64(k.Precomputed.Dp.Bytes()),
+			Dq:  b64(k.Precomputed.Dq.Bytes()),
+			Qi:  b64(k.Precomputed.Qinv.Bytes()),
+		}, nil
+	case *ecdsa.PrivateKey:
+		p := k.Curve.Params()
+		n := (p.BitSize + 7) / 8
+		alg := "ES256"
+		switch p.BitSize {
+		case 384:
+			alg = "ES384"
+		case 521:
+			alg = "ES512"
+		}
+		return &jsonWebKey{
+			Kty: "EC",
+			Kid: kid,
+			Alg: alg,
+			Crv: p.Name,
+			X:   b64(k.X.FillBytes(make([]byte, n))),
+			Y:   b64(k.Y.FillBytes(make([]byte, n))),
+			D:   b64(k.D.FillBytes(make([]byte, n))),
+		}, nil
+	}
+	return nil, fmt.Errorf("%s key cannot be exported as a JWK", keyDesc(k.Public()))
+}
+
+// b64 returns the unpadded base64url encoding of b, as used in JWKs.
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestPrivateJWK(t *testing.T) {
+	const kid = "https://ca.example.org/acme/reg/1"
+	num := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	decode := func(k crypto.Signer) *jsonWebKey {
+		jwk, err := privateJWK(k, kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(jwk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res jsonWebKey
+		if err := json.Unmarshal(b, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Kid != kid {
+			t.Errorf("kid = %q; want %q", res.Kid, kid)
+		}
+		return &res
+	}
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := decode(rk)
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" {
+		t.Errorf("RSA kty, alg = %q, %q", jwk.Kty, jwk.Alg)
+	}
+	got := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: num(jwk.N), E: int(num(jwk.E).Int64())},
+		D:         num(jwk.D),
+		Primes:    []*big.Int{num(jwk.P), num(jwk.Q)},
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("RSA JWK: %v", err)
+	}
+	got.Precompute()
+	if got.Precomputed.Qinv.Cmp(num(jwk.Qi)) != 0 || got.Precomputed.Dp.Cmp(num(jwk.Dp)) != 0 {
+		t.Error("RSA JWK CRT values differ")
+	}
+	if !got.Equal(rk) {
+		t.Error("RSA JWK is not the key")
+	}
+
+	for _, c := range []struct {
+		curve elliptic.Curve
+		alg   string
+		size  int
+	}{
+		{elliptic.P256(), "ES256", 32},
+		{elliptic.P384(), "ES384", 48},
+		{elliptic.P521(), "ES512", 66},
+	} {
+		ek, err := ecdsa.GenerateKey(c.curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk := decode(ek)
+		name := c.curve.Params().Name
+		if jwk.Kty != "EC" || jwk.Crv != name || jwk.Alg != c.alg {
+			t.Errorf("%s kty, crv, alg = %q, %q, %q", name, jwk.Kty, jwk.Crv, jwk.Alg)
+		}
+		for _, v := range []string{jwk.X, jwk.Y, jwk.D} {
+			if b, _ := base64.RawURLEncoding.DecodeString(v); len(b) != c.size {
+				t.Errorf("%s coordinate is %d bytes; want %d", name, len(b), c.size)
+			}
+		}
+		got := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: c.curve, X: num(jwk.X), Y: num(jwk.Y)},
+			D:         num(jwk.D),
+		}
+		if !got.Equal(ek) {
+			t.Errorf("%s JWK is not the key", name)
+		}
+	}
+}
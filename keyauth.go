@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdKeyauth = &command{
+		run:       runKeyauth,
+		UsageLine: "keyauth [-c config] token [value]",
+		Short:     "compute challenge responses for a token",
+		Long: `
+Keyauth computes the values the CA expects in response to a challenge
+with the specified token, using the account key.
+
+The following values are printed:
+
+	the URL path of the http-01 challenge response
+	the http-01 key authorization, token.thumbprint
+	the dns-01 TXT record value, base64url encoded SHA-256 of the above
+
+This is useful to publish challenge responses by hand or to verify
+what has been published. If value is specified, it is compared
+against the http-01 and dns-01 responses instead, and keyauth
+exits with a non-zero status if it matches neither.
+
+No request is made to the CA.
+`,
+	}
+)
+
+func runKeyauth(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fatalf("expected a token and an optional value")
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	client := &acme.Client{Key: uc.key}
+	token := args[0]
+	http01, err := client.HTTP01ChallengeResponse(token)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	dns01, err := client.DNS01ChallengeRecord(token)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if len(args) == 2 {
+		switch args[1] {
+		case http01:
+			fmt.Println("value matches the http-01 response")
+		case dns01:
+			fmt.Println("value matches the dns-01 response")
+		default:
+			fatalf("value matches neither the http-01 nor the dns-01 response")
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(tw, "Path:\t", client.HTTP01ChallengePath(token))
+	fmt.Fprintln(tw, "http-01:\t", http01)
+	fmt.Fprintln(tw, "dns-01:\t", dns01)
+	tw.Flush()
+}
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"os"
+)
+
+// fileLocking reports whether lockFile locks files on this system.
+const fileLocking = false
+
+// lockFile does nothing: there is no portable advisory lock
+// on this system, so concurrent runs are not prevented.
+func lockFile(f *os.File) error {
+	return nil
+}
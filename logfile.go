@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	// logFile is the file log messages are appended to, in addition
+	// to the standard error. It is set with -log-file flag,
+	// common to all subcommands.
+	logFile string
+	// logMaxSize is the size in bytes above which logFile is rotated
+	// when opened, set with -log-max-size flag.
+	logMaxSize int64 = 10 << 20
+)
+
+// setupLogFile opens logFile, if any, and makes the log package write to it
+// as well as the standard error. The previous file is kept with a .1 suffix
+// if it exceeds logMaxSize.
+// Each line written to the file is prefixed with a timestamp and name,
+// the subcommand being run.
+func setupLogFile(name string) error {
+	if logFile == "" {
+		return nil
+	}
+	if fi, err := os.Stat(logFile); err == nil && logMaxSize > 0 && fi.Size() > logMaxSize {
+		if err := os.Rename(logFile, logFile+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, &stampWriter{w: f, name: name}))
+	return nil
+}
+
+// stampWriter prefixes each line written to w with the current time
+// in RFC 3339 format and name.
+type stampWriter struct {
+	w    io.Writer
+	name string
+}
+
+func (s *stampWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	prefix := time.Now().Format(time.RFC3339) + " " + s.name + ": "
+	for _, l := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(l) == 0 {
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.Write(l)
+	}
+	if out := buf.Bytes(); len(out) > 0 && out[len(out)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -12,11 +12,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 )
 
 // defaultDisco is the default CA directory endpoint.
@@ -37,17 +40,43 @@ var (
 	// commands lists all available commands and help topics.
 	// The order here is the order in which they are printed by 'acme help'.
 	commands = []*command{
+		cmdGenkey,
+		cmdInit,
 		cmdReg,
 		cmdWho,
+		cmdTrust,
+		cmdDirectory,
 		cmdUpdate,
+		cmdPasswd,
+		cmdTerms,
+		cmdDefaults,
 		cmdCert,
+		cmdRekey,
+		cmdRevoke,
+		cmdCleanup,
+		cmdInfo,
+		cmdInspect,
+		cmdCRLCheck,
+		cmdStatus,
+		cmdSplit,
+		cmdHash,
+		cmdPin,
+		cmdTestkey,
+		cmdKeyauth,
+		cmdDNSTest,
+		cmdDoctor,
+		cmdBackup,
+		cmdRestore,
+		cmdExportJWK,
 		// help commands, non-executable
 		helpAccount,
 		helpDisco,
+		helpFlags,
 	}
 
-	exitMu     sync.Mutex // guards exitStatus
+	exitMu     sync.Mutex // guards exitStatus and exitFuncs
 	exitStatus = 0
+	exitFuncs  []func() // run by exit in reverse order
 )
 
 var logf = log.Printf
@@ -70,10 +99,45 @@ func setExitStatus(n int) {
 	exitMu.Unlock()
 }
 
+// atExit registers f to be called by exit, before the process terminates.
+func atExit(f func()) {
+	exitMu.Lock()
+	exitFuncs = append(exitFuncs, f)
+	exitMu.Unlock()
+}
+
 func exit() {
+	exitMu.Lock()
+	fns := exitFuncs
+	exitFuncs = nil
+	exitMu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
 	os.Exit(exitStatus)
 }
 
+// withSignals returns a copy of parent which is cancelled
+// when the process receives SIGINT or SIGTERM.
+// The returned cancel func also stops the signal relay.
+func withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-c:
+			logf("%v: cleaning up", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(c)
+		cancel()
+	}
+}
+
 func main() {
 	flag.Usage = usage
 	flag.Parse() // catch -h argument
@@ -93,6 +157,9 @@ func main() {
 			addFlags(&cmd.flag)
 			cmd.flag.Usage = func() { cmd.Usage() }
 			cmd.flag.Parse(args[1:])
+			if err := setupLogFile(cmd.Name()); err != nil {
+				fatalf("log file: %v", err)
+			}
 			cmd.run(cmd.flag.Args())
 			exit()
 			return
@@ -106,6 +173,17 @@ func main() {
 // Common flag var names are of flagXxx form.
 func addFlags(f *flag.FlagSet) {
 	f.StringVar(&configDir, "c", configDir, "")
+	f.Var(&configCA, "ca", "")
+	f.StringVar(&configFile, "account-file", configFile, "")
+	f.StringVar(&configKeyFile, "account-key-file", configKeyFile, "")
+	f.StringVar(&userAgent, "user-agent", userAgent, "")
+	f.Var(&clientProxy, "proxy", "")
+	f.Var(&clientCACert, "ca-cert", "")
+	f.DurationVar(&clientHTTPTimeout, "http-timeout", clientHTTPTimeout, "")
+	f.Var(&dnsResolver, "resolver", "")
+	f.BoolVar(&configCheckKey, "check-key", false, "")
+	f.StringVar(&logFile, "log-file", "", "")
+	f.Int64Var(&logMaxSize, "log-max-size", logMaxSize, "")
 }
 
 // A command is an implementation of a acme command
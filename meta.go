@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// certMeta is metadata about a managed certificate,
+// stored in a sidecar file alongside the certificate.
+type certMeta struct {
+	// Hash is the bundleHash of the current certificate and key.
+	Hash string `json:"hash,omitempty"`
+	// Deployed is the Hash value of the last successful deploy.
+	Deployed string `json:"deployed,omitempty"`
+	// Issuer is the common name of the issuer at the top of the chain
+	// obtained at first issuance. See chainIssuers.
+	Issuer string `json:"issuer,omitempty"`
+	// Domains are the names requested at the last issuance.
+	Domains []string `json:"domains,omitempty"`
+	// Challenges maps each of Domains to the challenge type
+	// selected for it at the last issuance.
+	Challenges map[string]string `json:"challenges,omitempty"`
+
+	// encrypted reports whether the sidecar was read encrypted,
+	// so that writeMeta keeps it encrypted.
+	encrypted bool
+}
+
+// metaPath returns the sidecar file name for the certificate at certPath.
+func metaPath(certPath string) string {
+	return strings.TrimSuffix(certPath, ".crt") + ".json"
+}
+
+// readMeta reads sidecar metadata of the certificate at certPath,
+// decrypting it with the keyPassEnv passphrase if it is encrypted.
+// A missing sidecar results in zero metadata.
+func readMeta(certPath string) (*certMeta, error) {
+	m := &certMeta{}
+	path := metaPath(certPath)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d, _ := pem.Decode(b); d != nil && d.Type == encryptedMeta {
+		if b, err = decryptMeta(d); err != nil {
+			return nil, fmt.Errorf("%s is encrypted: %v", path, err)
+		}
+		m.encrypted = true
+	}
+	return m, json.Unmarshal(b, m)
+}
+
+// writeMeta stores m in the sidecar of the certificate at certPath.
+// The sidecar is encrypted if m was read encrypted or -encrypt is set.
+func writeMeta(certPath string, m *certMeta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if m.encrypted || certEncrypt {
+		if b, err = encryptMeta(b); err != nil {
+			return err
+		}
+		perm = 0600
+	}
+	return writeFileAtomic(metaPath(certPath), b, perm)
+}
+
+// lockPath returns the lock file name for the certificate at certPath.
+func lockPath(certPath string) string {
+	return strings.TrimSuffix(certPath, ".crt") + ".lock"
+}
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("file is locked")
+
+// lockCert acquires an exclusive lock on the certificate at certPath,
+// so that a concurrent acme process does not renew the same certificate.
+// Other certificates are not affected.
+//
+// The lock is an advisory lock on the lock file, see lockFile, held until
+// the process exits, even if it is killed. The file records the holder's
+// PID and is left in place; a leftover file does not block later runs.
+func lockCert(certPath string) error {
+	if !fileLocking {
+		logf("warning: file locking is not supported on this system; %s is not locked", certPath)
+	}
+	p := lockPath(certPath)
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		b, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err == errLocked {
+			return fmt.Errorf("%s is locked by another process (%s)", certPath, strings.TrimSpace(string(b)))
+		}
+		return fmt.Errorf("lock %s: %v", p, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "pid %d since %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	}
+	atExit(func() { f.Close() })
+	return nil
+}
+
+// bundleHash returns hex encoded SHA-256 of the contents of the
+// certificate chain file followed by the key file.
+func bundleHash(certPath, keyPath string) (string, error) {
+	h := sha256.New()
+	for _, p := range []string{certPath, keyPath} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
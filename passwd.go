@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	cmdPasswd = &command{
+		run:       runPasswd,
+		UsageLine: "passwd [-c config] [file]",
+		Short:     "change the passphrase of an account key",
+		Long: `
+Passwd changes the passphrase of the encrypted account key of the account
+selected with -ca, or of the key in file. An unencrypted key is encrypted.
+The key is written as a PKCS#8 ENCRYPTED PRIVATE KEY block, also when it
+was encrypted in the legacy PEM format of earlier versions, so passwd
+converts such keys. The key file is replaced atomically, and the decrypted
+key is never written to disk.
+
+The current passphrase is read from {{.KeyPassEnv}} environment variable,
+and the new one from {{.NewKeyPassEnv}}. If either is not set, it is
+prompted for on the terminal.
+
+Afterwards, set {{.KeyPassEnv}} to the new passphrase for other commands.
+See also acme help account.
+`,
+	}
+)
+
+// newKeyPassEnv is the environment variable holding the new passphrase
+// for the passwd command.
+const newKeyPassEnv = "ACME_NEW_KEY_PASS"
+
+func runPasswd(args []string) {
+	var path string
+	switch len(args) {
+	case 0:
+		uc, err := readConfig()
+		if err != nil {
+			fatalf("read config: %v", err)
+		}
+		path = uc.keyPath()
+	case 1:
+		path = args[0]
+	default:
+		fatalf("too many arguments")
+	}
+
+	enc, err := isEncryptedKey(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if enc && os.Getenv(keyPassEnv) == "" {
+		p, err := readPassphrase("Current passphrase: ")
+		if err != nil {
+			fatalf("current passphrase: %v", err)
+		}
+		os.Setenv(keyPassEnv, p)
+	}
+	k, err := readKey(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	pass := os.Getenv(newKeyPassEnv)
+	if pass == "" {
+		if pass, err = readPassphrase("New passphrase: "); err != nil {
+			fatalf("new passphrase: %v", err)
+		}
+		again, err := readPassphrase("Repeat new passphrase: ")
+		if err != nil {
+			fatalf("new passphrase: %v", err)
+		}
+		if again != pass {
+			fatalf("passphrases do not match")
+		}
+	}
+	if pass == "" {
+		fatalf("new passphrase is empty")
+	}
+	if err := encryptKeyFile(path, k, pass); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// encryptKeyFile atomically replaces the key file at path with k
+// encrypted with pass, see keyPEM.
+func encryptKeyFile(path string, k crypto.Signer, pass string) error {
+	b, err := keyPEM(k, []byte(pass))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, pem.EncodeToMemory(b), 0600)
+}
+
+// isEncryptedKey reports whether the PEM file at path
+// contains an encrypted key block, see isEncryptedBlock.
+func isEncryptedKey(path string) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for {
+		var d *pem.Block
+		if d, b = pem.Decode(b); d == nil {
+			return false, nil
+		}
+		if isEncryptedBlock(d) {
+			return true, nil
+		}
+	}
+}
+
+// readPassphrase prints prompt to the standard error and reads a line
+// from the terminal, with echo disabled using stty.
+func readPassphrase(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", errors.New("standard input is not a terminal")
+	}
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("disable echo: %v", err)
+	}
+	defer func() {
+		stty("echo")
+		fmt.Fprintln(os.Stderr)
+	}()
+	s, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s, "\r\n"), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptKeyFileConvertsLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(keyPassEnv, os.Getenv(keyPassEnv))
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, ecPrivateKey, der, []byte("old"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "account.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if enc, err := isEncryptedKey(path); err != nil || !enc {
+		t.Fatalf("legacy key: encrypted = %v, %v", enc, err)
+	}
+
+	os.Setenv(keyPassEnv, "old")
+	read, err := readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptKeyFile(path, read, "new"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := pem.Decode(b); d == nil || d.Type != encryptedPrivateKey {
+		t.Fatal("key is not an encrypted PKCS#8 key")
+	}
+	if enc, err := isEncryptedKey(path); err != nil || !enc {
+		t.Errorf("converted key: encrypted = %v, %v", enc, err)
+	}
+	if _, err := readKey(path); err == nil {
+		t.Error("readKey with the old passphrase: nil error")
+	}
+	os.Setenv(keyPassEnv, "new")
+	read, err = readKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(read, k) {
+		t.Error("converted key differs")
+	}
+}
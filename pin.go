@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+var (
+	cmdPin = &command{
+		run:       runPin,
+		UsageLine: "pin [-key] file",
+		Short:     "print the public key pin of a certificate or key",
+		Long: `
+Pin prints the base64 encoded SHA-256 of the SubjectPublicKeyInfo
+of the certificate in file, the value used for public key pinning
+and by some monitoring systems.
+
+With the -key flag, file is a private key file instead, so the pin of
+a key can be computed before it is used in a certificate, such as when
+publishing the pin of the next key before switching to it with cert -k.
+`,
+	}
+
+	pinKey bool
+)
+
+func init() {
+	cmdPin.flag.BoolVar(&pinKey, "key", pinKey, "")
+}
+
+func runPin(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one file")
+	}
+	var (
+		spki []byte
+		err  error
+	)
+	if pinKey {
+		spki, err = keySPKI(args[0])
+	} else {
+		var crt *x509.Certificate
+		crt, err = readCrt(args[0])
+		if crt != nil {
+			spki = crt.RawSubjectPublicKeyInfo
+		}
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Println(pin(spki))
+}
+
+// keySPKI returns the DER encoded SubjectPublicKeyInfo
+// of the public part of the key at path.
+func keySPKI(path string) ([]byte, error) {
+	k, err := readKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(k.Public())
+}
+
+// pin returns the base64 encoded SHA-256 of the DER encoded spki.
+func pin(spki []byte) string {
+	h := sha256.Sum256(spki)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
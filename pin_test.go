@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "example.org.key")
+	key, err := anyKey(path, true, keySpec{Type: "ec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := keySPKI(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pin(spki), pin(crt.RawSubjectPublicKeyInfo); got != want {
+		t.Errorf("key pin = %q; want cert pin %q", got, want)
+	}
+	if n := len(pin(spki)); n != 44 {
+		t.Errorf("len(pin) = %d; want 44", n)
+	}
+}
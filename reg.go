@@ -14,6 +14,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -24,75 +26,240 @@ import (
 var (
 	cmdReg = &command{
 		run:       runReg,
-		UsageLine: "reg [-c config] [-gen] [-accept] [-d url] [contact [contact ...]]",
+		UsageLine: "reg [-c config] [-gen [-keytype rsa|ec] | -account-key file] [-no-key-gen] [-accept] [-require-tos-accept] [-print-jws] [-d url] [-email addr] [-contact uri] [contact [contact ...]]",
 		Short:     "new account registration",
 		Long: `
 Reg creates a new account at a CA using the discovery URL
-specified with -d argument. The default value is {{.DefaultDisco}}.
+specified with -ca or -d argument. If neither is specified, the CA of
+the default account in the config is used, so that running reg again
+is idempotent, or {{.DefaultDisco}} if there is no config yet.
 For more information about the discovery run acme help disco.
 
+If the account key is already registered at the CA, its existing account
+is stored instead, keeping its contacts; use acme update to change them.
+The stored defaults, key file and record of accepted terms are kept.
+
+Registering at another CA adds an account to the config, recording
+the CA discovery URL with it; see acme help account.
+
 Upon successful registration, a new config will be written to {{.AccountFile}}
 in the directory specified with -c argument. Default location of the config dir
 is {{.ConfigDir}}.
 If the config dir does not exist, it will be created.
 
-Contact arguments can be anything: email, phone number, etc.
+Contact arguments are URIs of any scheme the CA accepts, such as
+mailto:admin@example.org. They are sent to the CA unchanged.
+Contacts may also be given with the -contact flag, and email addresses
+with the -email flag, which adds the mailto: scheme. Both may be repeated.
 
 The -gen flag will generate an RSA 2048 bit keypair to use as the account key.
+The -keytype flag selects rsa or ec, an ECDSA P-256 key, instead, as for
+the genkey command. The account key type is independent of the type of
+certificate keys, set with cert -cert-curve or the defaults command, so
+certificates with EC keys can be obtained with an RSA account at CAs
+which reject EC account keys.
 
 If -gen flag is not specified, and a file named account.key containing
 a PEM-encoded ECDSA or RSA private key does not exist, the command will exit
 with an error.
 
+The -account-key flag specifies a pre-generated account key file instead,
+such as one made with the genkey command or managed outside of the config
+dir. The key is not copied: its location is recorded for the account in
+{{.AccountFile}}, and later commands read the key from there. This differs
+from the -account-key-file flag, which only renames the key file within
+the config dir for a single run. A key recorded outside of the config dir
+is not included by the backup command.
+
+The -no-key-gen flag disables key generation even if -gen is also given.
+Use it in automation to make sure a lost account key is reported as an error
+instead of being silently replaced with a new key, which would orphan the
+existing CA account.
+
 The registration may require the user to agree to the CA Terms of Service (TOS).
 If so, and the -accept argument is not provided, the command prompts the user
 with a TOS URL provided by the CA.
 
+The -require-tos-accept flag makes the command refuse to register unless
+-accept is also given, so that the terms are never agreed to implicitly.
+The accepted TOS URL is then logged.
+
+The accepted TOS URL is recorded in {{.AccountFile}}, along with the time
+of acceptance and the SHA-256 of the terms document. Use acme terms
+to check whether the document changed since.
+
+The -print-jws flag prints each signed request sent to the CA
+to the standard error, with the JWS protected header and payload decoded,
+for debugging CA interoperability issues. The private key is never printed.
+
 See also: acme help account.
 `,
 	}
 
-	regDisco  = defaultDiscoFlag
-	regGen    bool
-	regAccept bool
+	regDisco      discoAliasFlag
+	regGen        bool
+	regNoKeyGen   bool
+	regKeyType    = defaultKeySpec.Type
+	regAccept     bool
+	regContacts   contactList
+	regRequireTOS bool
+	regAccountKey string
 )
 
 func init() {
 	cmdReg.flag.Var(&regDisco, "d", "")
 	cmdReg.flag.BoolVar(&regGen, "gen", regGen, "")
+	cmdReg.flag.BoolVar(&regNoKeyGen, "no-key-gen", regNoKeyGen, "")
+	cmdReg.flag.StringVar(&regKeyType, "keytype", regKeyType, "")
 	cmdReg.flag.BoolVar(&regAccept, "accept", regAccept, "")
+	cmdReg.flag.StringVar(&regAccountKey, "account-key", regAccountKey, "")
+	cmdReg.flag.BoolVar(&clientPrintJWS, "print-jws", clientPrintJWS, "")
+	cmdReg.flag.BoolVar(&regRequireTOS, "require-tos-accept", regRequireTOS, "")
+	cmdReg.flag.Var(&regContacts, "contact", "")
+	cmdReg.flag.Var(emailList{&regContacts}, "email", "")
 }
 
 func runReg(args []string) {
-	key, err := anyKey(filepath.Join(configDir, accountKey), regGen)
+	contact, err := contacts(args, regContacts)
 	if err != nil {
-		fatalf("account key: %v", err)
+		fatalf("%v", err)
+	}
+	if regRequireTOS && !regAccept {
+		fatalf("-require-tos-accept is set; refusing to register without -accept")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
 	}
 	uc := &userConfig{
-		Account: acme.Account{Contact: args},
-		key:     key,
+		Account: acme.Account{Contact: contact},
+		CA:      regDirectory(),
+	}
+	keyPath := filepath.Join(configDir, configKeyFile)
+	if prev := storedAccount(uc.CA); prev != nil && regAccountKey == "" {
+		// registering again uses the key of the stored account
+		uc.Key = prev.Key
+		keyPath = prev.keyPath()
+	}
+	if regAccountKey != "" {
+		if regGen {
+			fatalf("-gen cannot be used with -account-key")
+		}
+		keyPath = regAccountKey
+		if uc.Key, err = configKeyName(keyPath); err != nil {
+			fatalf("account key: %v", err)
+		}
+	}
+	spec := defaultKeySpec
+	if regKeyType != spec.Type {
+		spec = keySpec{Type: regKeyType}
+	}
+	uc.key, err = anyKey(keyPath, regGen && !regNoKeyGen, accountKeySpec(spec))
+	if os.IsNotExist(err) && regNoKeyGen {
+		fatalf("account key %s does not exist and -no-key-gen is set", keyPath)
+	}
+	if err != nil {
+		fatalf("account key: %v", err)
 	}
 
 	prompt := ttyPrompt
 	if regAccept {
 		prompt = acme.AcceptTOS
 	}
-	client := &acme.Client{
-		Key:          uc.key,
-		DirectoryURL: string(regDisco),
+	if regRequireTOS {
+		prompt = func(tos string) bool {
+			logf("accepting CA terms %s", tos)
+			return true
+		}
 	}
+	if err := register(uc, prompt); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// regDirectory returns the discovery URL of the CA to register at:
+// configCA or regDisco, if set, or else the CA of the default account
+// in the config file, or the default CA.
+func regDirectory() string {
+	if configCA != "" {
+		return string(configCA)
+	}
+	if regDisco != "" {
+		return string(regDisco)
+	}
+	if cur, err := readConfigFile(); err == nil && cur.CA != "" {
+		return cur.CA
+	}
+	return string(defaultDiscoFlag)
+}
+
+// register creates a new account described by uc at uc.CA
+// and writes the result to the config file.
+// The prompt is called if the CA requires accepting its terms,
+// and the time of acceptance and hash of the terms are recorded in uc.
+//
+// If the key is already registered at uc.CA, the existing account is
+// used; its contacts are not changed, which acme update does.
+// If the config already has an account at uc.CA, its stored defaults
+// and the record of accepted terms are kept, unless the terms are
+// accepted again, and so is its key file if uc uses the same key.
+func register(uc *userConfig, prompt func(tos string) bool) error {
+	client := newClient(uc.key, uc.CA)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	a, err := client.Register(ctx, &uc.Account, prompt)
+	accept := func(tos string) bool {
+		if !prompt(tos) {
+			return false
+		}
+		now := time.Now()
+		uc.TermsAcceptedAt = &now
+		h, err := termsHash(ctx, tos)
+		if err != nil {
+			logf("warning: terms hash not recorded: %v", err)
+		}
+		uc.TermsHash = h
+		return true
+	}
+	a, err := client.Register(ctx, &uc.Account, accept)
+	if e, ok := err.(*acme.Error); ok && e.StatusCode == http.StatusConflict && e.Header.Get("Location") != "" {
+		// the key is already registered; the CA returns its account
+		a, err = existingAccount(ctx, client, e.Header.Get("Location"), accept)
+	}
 	if err != nil {
-		fatalf("%v", err)
+		return err
 	}
 	uc.Account = *a
+	if prev := storedAccount(uc.CA); prev != nil {
+		if uc.Defaults == nil {
+			uc.Defaults = prev.Defaults
+		}
+		if uc.TermsAcceptedAt == nil {
+			uc.TermsAcceptedAt, uc.TermsHash = prev.TermsAcceptedAt, prev.TermsHash
+		}
+		if k, err := readKey(prev.keyPath()); uc.Key == "" && err == nil && sameKey(k, uc.key) {
+			uc.Key = prev.Key
+		}
+	}
 	if err := writeConfig(uc); err != nil {
-		errorf("write config: %v", err)
+		return fmt.Errorf("write config: %v", err)
+	}
+	return nil
+}
+
+// existingAccount returns the account at uri, which the CA reported
+// for an already registered key, prompting to accept its current terms
+// the same way acme.Client.Register does.
+func existingAccount(ctx context.Context, client *acme.Client, uri string, prompt func(tos string) bool) (*acme.Account, error) {
+	a, err := client.GetReg(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if a.CurrentTerms != "" && a.CurrentTerms != a.AgreedTerms && prompt(a.CurrentTerms) {
+		a.AgreedTerms = a.CurrentTerms
+		return client.UpdateReg(ctx, a)
 	}
+	return a, nil
 }
 
 func ttyPrompt(tos string) bool {
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+// newTestCA returns a fake ACME v1 CA holding a single account with
+// the URI id. Like Boulder, it creates the account on the first registration
+// and answers later ones with 409 Conflict and the account URI.
+// It requires agreeing to its terms at /terms.
+func newTestCA(id string) *httptest.Server {
+	var (
+		ts         *httptest.Server
+		registered bool
+		agreement  string
+	)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		reg := ts.URL + "/reg/" + id
+		switch {
+		case r.Method == "HEAD":
+		case r.URL.Path == "/directory":
+			fmt.Fprintf(w, `{"new-reg": %q}`, ts.URL+"/new-reg")
+		case r.URL.Path == "/new-reg" && registered:
+			w.Header().Set("Location", reg)
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"type": "urn:acme:error:malformed", "detail": "Registration key is already in use"}`)
+		case r.URL.Path == "/new-reg":
+			registered = true
+			w.Header().Set("Location", reg)
+			w.Header().Set("Link", fmt.Sprintf("<%s/terms>;rel=\"terms-of-service\"", ts.URL))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"contact": ["mailto:admin@example.org"]}`)
+		case r.URL.Path == "/terms":
+			fmt.Fprint(w, "terms")
+		case r.URL.Path == "/reg/"+id:
+			var req struct{ Agreement string }
+			json.Unmarshal(jwsPayload(r), &req)
+			if req.Agreement != "" {
+				agreement = req.Agreement
+			}
+			w.Header().Set("Link", fmt.Sprintf("<%s/terms>;rel=\"terms-of-service\"", ts.URL))
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"contact": ["mailto:admin@example.org"], "agreement": %q}`, agreement)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+// jwsPayload returns the decoded payload of the JWS request body of r,
+// or nil if it is not a JWS.
+func jwsPayload(r *http.Request) []byte {
+	var jws struct{ Payload string }
+	if json.NewDecoder(r.Body).Decode(&jws) != nil {
+		return nil
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return b
+}
+
+func TestRegisterMultipleCAs(t *testing.T) {
+	staging := newTestCA("staging")
+	defer staging.Close()
+	prod := newTestCA("prod")
+	defer prod.Close()
+
+	dir, err := ioutil.TempDir("", "acme-reg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, ca discoAliasFlag) { configDir, configCA = d, ca }(configDir, configCA)
+	configDir = dir
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configCA = ""
+	if got := regDirectory(); got != string(defaultDiscoFlag) {
+		t.Errorf("regDirectory with no config = %q; want %q", got, defaultDiscoFlag)
+	}
+	for _, ca := range []*httptest.Server{staging, prod} {
+		configCA = discoAliasFlag(ca.URL + "/directory")
+		uc := &userConfig{CA: regDirectory(), key: key}
+		if err := register(uc, acme.AcceptTOS); err != nil {
+			t.Fatalf("register at %s: %v", configCA, err)
+		}
+	}
+	configCA = ""
+	if got, want := regDirectory(), staging.URL+"/directory"; got != want {
+		t.Errorf("regDirectory = %q; want stored %q", got, want)
+	}
+
+	for ca, id := range map[*httptest.Server]string{staging: "staging", prod: "prod"} {
+		configCA = discoAliasFlag(ca.URL + "/directory")
+		uc, err := readConfig()
+		if err != nil {
+			t.Errorf("readConfig at %s: %v", configCA, err)
+			continue
+		}
+		if uc.CA != string(configCA) {
+			t.Errorf("uc.CA = %q; want %q", uc.CA, configCA)
+		}
+		if want := ca.URL + "/reg/" + id; uc.URI != want {
+			t.Errorf("uc.URI = %q; want %q", uc.URI, want)
+		}
+	}
+}
+
+func TestContacts(t *testing.T) {
+	var list contactList
+	if err := list.Set("tel:+12025550100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (emailList{&list}).Set("admin@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := contacts([]string{"mailto:a@example.org"}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mailto:a@example.org", "tel:+12025550100", "mailto:admin@example.org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("contacts = %q; want %q", got, want)
+	}
+	for _, v := range []string{"admin@example.org", "mailto:", ""} {
+		if _, err := contacts([]string{v}, nil); err == nil {
+			t.Errorf("contacts(%q): nil error", v)
+		}
+	}
+	if err := (emailList{&list}).Set("mailto:admin@example.org"); err == nil {
+		t.Error("email with scheme: nil error")
+	}
+}
+
+func TestRegisterRecordsTerms(t *testing.T) {
+	ca := newTestCA("terms")
+	defer ca.Close()
+	dir, err := ioutil.TempDir("", "acme-reg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { configDir = d }(configDir)
+	configDir = dir
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := &userConfig{CA: ca.URL + "/directory", key: key}
+	if err := register(uc, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if uc.AgreedTerms != "" || uc.TermsAcceptedAt != nil {
+		t.Errorf("declined terms recorded: %q at %v", uc.AgreedTerms, uc.TermsAcceptedAt)
+	}
+
+	uc = &userConfig{CA: ca.URL + "/directory", key: key}
+	if err := register(uc, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	if want := ca.URL + "/terms"; uc.AgreedTerms != want {
+		t.Errorf("uc.AgreedTerms = %q; want %q", uc.AgreedTerms, want)
+	}
+	if uc.TermsAcceptedAt == nil {
+		t.Error("uc.TermsAcceptedAt is nil")
+	}
+	h, err := termsHash(context.Background(), uc.AgreedTerms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uc.TermsHash != h || h == "" {
+		t.Errorf("uc.TermsHash = %q; want %q", uc.TermsHash, h)
+	}
+}
+
+func TestRegisterTwiceKeepsConfig(t *testing.T) {
+	ca := newTestCA("again")
+	defer ca.Close()
+	dir, err := ioutil.TempDir("", "acme-reg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { configDir = d }(configDir)
+	configDir = dir
+
+	keyPath := filepath.Join(dir, "keys", "custom.key")
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	key, err := anyKey(keyPath, true, keySpec{Type: "ec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disco := ca.URL + "/directory"
+	uc := &userConfig{CA: disco, Key: filepath.Join("keys", "custom.key"), key: key}
+	if err := register(uc, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	reuse := false
+	uc.Defaults = &certDefaults{ReuseKey: &reuse, Challenge: "dns-01"}
+	if err := writeConfig(uc); err != nil {
+		t.Fatal(err)
+	}
+	first, err := readConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// run again without -account-key and declining the terms,
+	// as reg does when the account already exists
+	again := &userConfig{CA: disco, key: key}
+	if prev := storedAccount(disco); prev != nil {
+		again.Key = prev.Key
+	}
+	if err := register(again, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != first.Key {
+		t.Errorf("Key = %q; want %q", got.Key, first.Key)
+	}
+	if !reflect.DeepEqual(got.Defaults, first.Defaults) {
+		t.Errorf("Defaults = %+v; want %+v", got.Defaults, first.Defaults)
+	}
+	if got.TermsAcceptedAt == nil || !got.TermsAcceptedAt.Equal(*first.TermsAcceptedAt) {
+		t.Errorf("TermsAcceptedAt = %v; want %v", got.TermsAcceptedAt, first.TermsAcceptedAt)
+	}
+	if got.TermsHash != first.TermsHash || got.TermsHash == "" {
+		t.Errorf("TermsHash = %q; want %q", got.TermsHash, first.TermsHash)
+	}
+	if got.URI != first.URI {
+		t.Errorf("URI = %q; want %q", got.URI, first.URI)
+	}
+
+	// the key is kept even if the caller did not set it
+	if err := register(&userConfig{CA: disco, key: key}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != first.Key {
+		t.Errorf("Key after register without Key = %q; want %q", got.Key, first.Key)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdRekey = &command{
+		run:       runRekey,
+		UsageLine: "rekey [-c config] [-d url] [-s host:port] [-k key] [-out-dir dir] [-manual=false] [-dns=false] [-challenge [domain=]type] [-challenge-fallback types] [-webroot [domain=]dir] [-acme-dns file] [-dns-propagation-timeout dur] [-dns-poll-interval dur] [-http-self-check [-self-check-addr host:port] [-self-check-proxy] [-self-check-attempts n] [-self-check-interval dur]] [-strict-chain [-roots file]] [-include-root] [-eku server|client|both] [-cert-curve P-256|P-384|P-521] [-key-format pkcs1|pkcs8] [-encrypt] [-deploy-hook cmd] [-print-jws] [-revoke] domain",
+		Short:     "reissue a certificate with a new key",
+		Long: `
+Rekey generates a new key for an existing certificate and reissues
+the certificate for the same domain names, regardless of its expiry.
+Use it to rotate a compromised certificate key. The account key and
+config are left untouched.
+
+The existing certificate is expected to be alongside the key file
+specified with -k argument. Default location for the key file is
+{{.ConfigDir}}/domain.key.
+
+The old key and certificate are kept with a .bak suffix.
+If -revoke is specified, the old certificate is then revoked
+with the keyCompromise reason.
+
+The certificate is locked as with the cert command; see acme help cert.
+
+The -d, -s, -out-dir, -manual, -dns, -challenge, -challenge-fallback,
+-webroot, -acme-dns, -dns-propagation-timeout, -dns-poll-interval, -http-self-check,
+-self-check-addr, -self-check-proxy, -self-check-attempts,
+-self-check-interval, -strict-chain, -roots, -include-root, -eku,
+-cert-curve, -key-format, -encrypt, -deploy-hook and -print-jws arguments have
+the same meaning as for the cert command. See acme help cert for details.
+`,
+	}
+
+	rekeyRevoke bool
+	rekeyFlags  *flag.FlagSet // cmdRekey.flag, to tell which flags are set
+)
+
+func init() {
+	cmdRekey.flag.Var(&certDisco, "d", "")
+	cmdRekey.flag.StringVar(&certAddr, "s", certAddr, "")
+	cmdRekey.flag.BoolVar(&certManual, "manual", certManual, "")
+	cmdRekey.flag.BoolVar(&certDNS, "dns", certDNS, "")
+	cmdRekey.flag.Var(certChallenge, "challenge", "")
+	cmdRekey.flag.Var(&certFallback, "challenge-fallback", "")
+	cmdRekey.flag.Var(certWebroot, "webroot", "")
+	cmdRekey.flag.StringVar(&certKeypath, "k", "", "")
+	cmdRekey.flag.StringVar(&certOutDir, "out-dir", "", "")
+	cmdRekey.flag.BoolVar(&certSelfCheck, "http-self-check", certSelfCheck, "")
+	cmdRekey.flag.StringVar(&certSelfCheckAddr, "self-check-addr", "", "")
+	cmdRekey.flag.BoolVar(&certSelfCheckProxy, "self-check-proxy", certSelfCheckProxy, "")
+	cmdRekey.flag.IntVar(&certSelfCheckAttempts, "self-check-attempts", certSelfCheckAttempts, "")
+	cmdRekey.flag.DurationVar(&certSelfCheckInterval, "self-check-interval", certSelfCheckInterval, "")
+	cmdRekey.flag.BoolVar(&certStrictChain, "strict-chain", certStrictChain, "")
+	cmdRekey.flag.StringVar(&certRoots, "roots", "", "")
+	cmdRekey.flag.BoolVar(&certRoot, "include-root", certRoot, "")
+	cmdRekey.flag.Var(&certEKU, "eku", "")
+	cmdRekey.flag.Var(&certCurve, "cert-curve", "")
+	cmdRekey.flag.Var(&keyFormat, "key-format", "")
+	cmdRekey.flag.BoolVar(&certEncrypt, "encrypt", certEncrypt, "")
+	cmdRekey.flag.StringVar(&certAcmeDNSFile, "acme-dns", "", "")
+	cmdRekey.flag.DurationVar(&certDNSTimeout, "dns-propagation-timeout", certDNSTimeout, "")
+	cmdRekey.flag.DurationVar(&certDNSPoll, "dns-poll-interval", certDNSPoll, "")
+	cmdRekey.flag.StringVar(&certDeployHook, "deploy-hook", "", "")
+	cmdRekey.flag.BoolVar(&clientPrintJWS, "print-jws", clientPrintJWS, "")
+	rekeyFlags = &cmdRekey.flag
+	cmdRekey.flag.BoolVar(&rekeyRevoke, "revoke", rekeyRevoke, "")
+}
+
+func runRekey(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one domain")
+	}
+	setupChallenge()
+	cn, err := normalizeDomain(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if certKeypath == "" {
+		certKeypath = filepath.Join(configDir, cn+".key")
+	}
+
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	if err := applyDefaults(rekeyFlags, uc.Defaults); err != nil {
+		fatalf("%v", err)
+	}
+
+	certPath := sameDir(certKeypath, cn+".crt")
+	if err := lockCert(certPath); err != nil {
+		fatalf("%v", err)
+	}
+	oldCrt, err := readCrt(certPath)
+	if err != nil {
+		fatalf("read cert: %v", err)
+	}
+	if _, err := readKey(certKeypath); err != nil {
+		fatalf("cert key: %v", err)
+	}
+
+	// the new key is moved into place only once the cert is issued
+	newKeypath := certKeypath + ".new"
+	if err := os.Remove(newKeypath); err != nil && !os.IsNotExist(err) {
+		fatalf("%v", err)
+	}
+	newKey, err := anyKey(newKeypath, true, certKeySpec())
+	if err != nil {
+		fatalf("cert key: %v", err)
+	}
+
+	dir := string(certDisco)
+	if configCA != "" {
+		dir = uc.CA
+	}
+	client := newClient(uc.key, dir)
+	ctx, stop := withSignals(context.Background())
+	defer stop()
+	if m, err := readMeta(certPath); err == nil {
+		certPreferred = m.Challenges
+	}
+	domains := certDomains(oldCrt)
+	cert, err := issueCert(ctx, client, oldCrt.Subject.CommonName, domains, newKey)
+	if err != nil {
+		os.Remove(newKeypath)
+		fatalf("%v", err)
+	}
+
+	// back up the old pair and replace it with the new one
+	if err := installCert(certPath, certKeypath, newKeypath, cn, cert); err != nil {
+		os.Remove(newKeypath)
+		fatalf("%v", err)
+	}
+	if err := recordIssuance(certPath, domains); err != nil {
+		fatalf("write meta: %v", err)
+	}
+	if err := deploy(certPath, certKeypath); err != nil {
+		fatalf("deploy: %v", err)
+	}
+
+	if rekeyRevoke {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if err := client.RevokeCert(ctx, nil, oldCrt.Raw, acme.CRLReasonKeyCompromise); err != nil {
+			fatalf("revoke: %v", err)
+		}
+	}
+}
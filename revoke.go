@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdRevoke = &command{
+		run:       runRevoke,
+		UsageLine: "revoke [-c config] [-d url] [-k key] [-reason name] [-serial hex | file]",
+		Short:     "revoke a certificate",
+		Long: `
+Revoke revokes the certificate stored in the file argument.
+
+Instead of a file, the serial number of the certificate may be specified
+in hex with -serial argument. The certificates in the config dir are then
+searched for one with that serial number. A certificate cannot be revoked
+by its serial number alone, so revoke fails if none is found.
+
+The request is signed with the account key, unless the certificate key
+is specified with -k argument. The latter allows to revoke certificates
+issued to another account.
+
+The -reason argument specifies the revocation reason, one of:
+
+	{{.RevokeReasons}}
+
+The default is unspecified.
+
+The -d argument specifies the CA discovery URL, as for the cert command.
+`,
+	}
+
+	revokeDisco   = defaultDiscoFlag
+	revokeKeypath string
+	revokeReason  = "unspecified"
+	revokeSerial  string
+)
+
+// revokeReasons maps names accepted by -reason to RFC 5280 reason codes.
+var revokeReasons = map[string]acme.CRLReasonCode{
+	"unspecified":          acme.CRLReasonUnspecified,
+	"keyCompromise":        acme.CRLReasonKeyCompromise,
+	"affiliationChanged":   acme.CRLReasonAffiliationChanged,
+	"superseded":           acme.CRLReasonSuperseded,
+	"cessationOfOperation": acme.CRLReasonCessationOfOperation,
+}
+
+func init() {
+	cmdRevoke.flag.Var(&revokeDisco, "d", "")
+	cmdRevoke.flag.StringVar(&revokeKeypath, "k", "", "")
+	cmdRevoke.flag.StringVar(&revokeReason, "reason", revokeReason, "")
+	cmdRevoke.flag.StringVar(&revokeSerial, "serial", "", "")
+}
+
+func runRevoke(args []string) {
+	reason, ok := revokeReasons[revokeReason]
+	if !ok {
+		fatalf("unknown revocation reason %q", revokeReason)
+	}
+	var (
+		crt *x509.Certificate
+		err error
+	)
+	switch {
+	case revokeSerial != "" && len(args) == 0:
+		crt, err = findSerial(revokeSerial)
+	case revokeSerial == "" && len(args) == 1:
+		crt, err = readCrt(args[0])
+	default:
+		fatalf("expected either a certificate file or -serial")
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	var key crypto.Signer
+	if revokeKeypath != "" {
+		if key, err = readKey(revokeKeypath); err != nil {
+			fatalf("cert key: %v", err)
+		}
+	}
+
+	dir := string(revokeDisco)
+	if configCA != "" {
+		dir = uc.CA
+	}
+	client := newClient(uc.key, dir)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := client.RevokeCert(ctx, key, crt.Raw, reason); err != nil {
+		fatalf("revoke: %v", err)
+	}
+	logf("revoked %X (%s)", crt.SerialNumber, strings.Join(certDomains(crt), ", "))
+}
+
+// findSerial returns the certificate with the hex encoded serial number
+// from the *.crt files in configDir.
+func findSerial(serial string) (*x509.Certificate, error) {
+	want, ok := new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial number %q", serial)
+	}
+	if err := checkConfigDir(); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(configDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		crt, err := readCrt(f)
+		if err != nil {
+			continue
+		}
+		if crt.SerialNumber.Cmp(want) == 0 {
+			logf("found %X in %s", want, f)
+			return crt, nil
+		}
+	}
+	return nil, fmt.Errorf("no certificate with serial %X in %s", want, configDir)
+}
+
+// revokeReasonNames returns the names accepted by -reason, sorted.
+func revokeReasonNames() string {
+	var names []string
+	for n := range revokeReasons {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// newPendingCA returns a fake ACME v1 CA offering http-01 and dns-01
+// challenges for any domain, whose authorizations never become valid.
+// Accepted challenge requests are sent to accepted.
+func newPendingCA(accepted chan<- string) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/directory":
+			fmt.Fprintf(w, `{"new-authz": %q}`, ts.URL+"/new-authz")
+		case "/new-authz":
+			if r.Method == "HEAD" {
+				return
+			}
+			w.Header().Set("Location", ts.URL+"/authz")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"status": "pending", "challenges": [
+				{"type": "http-01", "uri": %q, "token": "token"},
+				{"type": "dns-01", "uri": %q, "token": "token"}]}`,
+				ts.URL+"/chal/http-01", ts.URL+"/chal/dns-01")
+		case "/chal/http-01", "/chal/dns-01":
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"status": "pending"}`)
+			accepted <- r.URL.Path
+		case "/authz":
+			w.Header().Set("Retry-After", "1")
+			fmt.Fprint(w, `{"status": "pending"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestAuthzCancelHTTP01(t *testing.T) {
+	accepted := make(chan string, 1)
+	ca := newPendingCA(accepted)
+	defer ca.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key, DirectoryURL: ca.URL + "/directory"}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	defer func(a string, m bool) { certAddr, certManual = a, m }(certAddr, certManual)
+	certAddr, certManual = addr, false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		<-accepted
+		// the response is being served while the CA validates
+		res, err := http.Get("http://" + addr + "/.well-known/acme-challenge/token")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				err = fmt.Errorf("challenge response: %s", res.Status)
+			}
+		}
+		served <- err
+		cancel()
+	}()
+	done := make(chan error)
+	go func() { done <- authz(ctx, client, "example.org") }()
+	select {
+	case err := <-done:
+		// the error may be wrapped by the HTTP client
+		if err == nil {
+			t.Error("authz: nil error after cancel")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("authz did not return after cancel")
+	}
+	if err := <-served; err != nil {
+		t.Error(err)
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("challenge server at %s still listening after cancel", addr)
+	}
+}
+
+func TestSolveDNS01Cancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-solve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, m map[string]*acmeDNSAccount, w io.Writer) {
+		configDir, certAcmeDNS, promptOut = d, m, w
+	}(configDir, certAcmeDNS, promptOut)
+	configDir, certAcmeDNS, promptOut = dir, nil, ioutil.Discard
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = solveDNS01(ctx, client, "example.org", &acme.Challenge{Type: "dns-01", Token: "token"})
+	if err != context.Canceled {
+		t.Errorf("solveDNS01 = %v; want %v", err, context.Canceled)
+	}
+	// the manual record stays in the journal for acme cleanup
+	list, err := readJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Record != "_acme-challenge.example.org" {
+		t.Errorf("journal = %+v; want the _acme-challenge.example.org record", list)
+	}
+}
+
+func TestAuthzReuseValid(t *testing.T) {
+	var accepted []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/directory":
+			fmt.Fprintf(w, `{"new-authz": %q}`, ts.URL+"/new-authz")
+		case "/new-authz":
+			if r.Method == "HEAD" {
+				return
+			}
+			w.Header().Set("Location", ts.URL+"/authz")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"status": "valid", "challenges": [
+				{"type": "http-01", "uri": %q, "token": "token", "status": "valid"}]}`,
+				ts.URL+"/chal/http-01")
+		default:
+			accepted = append(accepted, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer delete(certUsedChallenges, "example.org")
+	client := newClient(key, ts.URL+"/directory")
+	if err := authz(context.Background(), client, "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 0 {
+		t.Errorf("requests to %q for a valid authorization", accepted)
+	}
+	if typ, ok := certUsedChallenges["example.org"]; ok {
+		t.Errorf("recorded solved %s challenge", typ)
+	}
+}
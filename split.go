@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	cmdSplit = &command{
+		run:       runSplit,
+		UsageLine: "split [-cert file] [-chain file] bundle",
+		Short:     "split a certificate bundle into leaf and chain",
+		Long: `
+Split reads a PEM bundle of concatenated certificates, such as one
+downloaded from a CA portal, and writes the leaf certificate and the
+intermediates to separate files.
+
+The leaf is the certificate that is not a CA, or else the one which is not
+the issuer of any other certificate in the bundle. The intermediates are
+written in order from the leaf's issuer upwards, whatever their order in
+the bundle.
+
+The -cert and -chain arguments specify the output files. By default they are
+written alongside the bundle, with its extension replaced by .cert.pem and
+.chain.pem.
+`,
+	}
+
+	splitCert  string
+	splitChain string
+)
+
+func init() {
+	cmdSplit.flag.StringVar(&splitCert, "cert", "", "")
+	cmdSplit.flag.StringVar(&splitChain, "chain", "", "")
+}
+
+func runSplit(args []string) {
+	if len(args) != 1 {
+		fatalf("expected exactly one bundle file")
+	}
+	crts, err := readCerts(args[0])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	leaf, chain, err := splitBundle(crts)
+	if err != nil {
+		fatalf("%s: %v", args[0], err)
+	}
+	base := strings.TrimSuffix(args[0], filepath.Ext(args[0]))
+	if splitCert == "" {
+		splitCert = base + ".cert.pem"
+	}
+	if splitChain == "" {
+		splitChain = base + ".chain.pem"
+	}
+	if err := writeCert(splitCert, [][]byte{leaf.Raw}); err != nil {
+		fatalf("%v", err)
+	}
+	var der [][]byte
+	for _, c := range chain {
+		der = append(der, c.Raw)
+	}
+	if err := writeCert(splitChain, der); err != nil {
+		fatalf("%v", err)
+	}
+	logf("leaf %s written to %s, %d intermediates to %s", leaf.Subject.CommonName, splitCert, len(chain), splitChain)
+}
+
+// splitBundle returns the leaf certificate of crts, and the rest ordered
+// from the leaf's issuer upwards. Certificates not part of the leaf's chain
+// follow in their original order.
+func splitBundle(crts []*x509.Certificate) (leaf *x509.Certificate, chain []*x509.Certificate, err error) {
+	var leaves []*x509.Certificate
+	for _, c := range crts {
+		if !c.IsCA {
+			leaves = append(leaves, c)
+		}
+	}
+	if len(leaves) != 1 {
+		leaves = nil
+		for _, c := range crts {
+			if !issuesAny(c, crts) {
+				leaves = append(leaves, c)
+			}
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return nil, nil, errors.New("no leaf certificate found")
+	case 1:
+		leaf = leaves[0]
+	default:
+		return nil, nil, errors.New("more than one leaf certificate found")
+	}
+
+	rest := make([]*x509.Certificate, 0, len(crts)-1)
+	for _, c := range crts {
+		if c != leaf {
+			rest = append(rest, c)
+		}
+	}
+	for cur := leaf; len(rest) > 0; {
+		i := 0
+		for ; i < len(rest); i++ {
+			if bytes.Equal(rest[i].RawSubject, cur.RawIssuer) {
+				break
+			}
+		}
+		if i == len(rest) {
+			break
+		}
+		cur = rest[i]
+		chain = append(chain, cur)
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return leaf, append(chain, rest...), nil
+}
+
+// issuesAny reports whether c is the issuer of a certificate in list
+// other than itself.
+func issuesAny(c *x509.Certificate, list []*x509.Certificate) bool {
+	for _, x := range list {
+		if x != c && bytes.Equal(x.RawIssuer, c.RawSubject) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+)
+
+func TestSplitBundle(t *testing.T) {
+	// issue creates a certificate named cn, signed by parent and its key,
+	// or self-signed if parent is nil.
+	issue := func(cn string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: cn},
+			IsCA:                  ca,
+			BasicConstraintsValid: true,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crt, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crt, key
+	}
+	root, rootKey := issue("Test Root", true, nil, nil)
+	inter, interKey := issue("Test Intermediate", true, root, rootKey)
+	leaf, _ := issue("example.org", false, inter, interKey)
+
+	got, chain, err := splitBundle([]*x509.Certificate{root, leaf, inter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != leaf {
+		t.Errorf("leaf = %s; want example.org", got.Subject.CommonName)
+	}
+	if len(chain) != 2 || chain[0] != inter || chain[1] != root {
+		var names []string
+		for _, c := range chain {
+			names = append(names, c.Subject.CommonName)
+		}
+		t.Errorf("chain = %q; want intermediate, root", names)
+	}
+
+	// a leaf marked as CA is found as the one issuing no other
+	caLeaf, _ := issue("ca.example.org", true, inter, interKey)
+	if got, _, err := splitBundle([]*x509.Certificate{inter, caLeaf}); err != nil || got != caLeaf {
+		t.Errorf("splitBundle with CA leaf = %v, %v", got, err)
+	}
+	if _, _, err := splitBundle([]*x509.Certificate{leaf, caLeaf, inter}); err != nil {
+		t.Errorf("splitBundle with one non-CA leaf: %v", err)
+	}
+	other, _ := issue("example.net", false, inter, interKey)
+	if _, _, err := splitBundle([]*x509.Certificate{leaf, other, inter}); err == nil {
+		t.Error("splitBundle with two leaves: nil error")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	cmdStatus = &command{
+		run:       runStatus,
+		UsageLine: "status [-c config] [-renew-at dur|pct%] [-json]",
+		Short:     "report renewal status of certificates",
+		Long: `
+Status reports which certificates in the config dir are due for renewal,
+without changing anything. For each .crt file, it prints the domain,
+the expiry time, the days left, whether the certificate is due now,
+and when it becomes due.
+
+The -renew-at argument has the same meaning as for the cert command,
+and defaults to {{.RenewAt}} before expiry.
+
+The -json flag makes the output a JSON array instead, with an object
+for each certificate holding its file paths, names, serial number, issuer,
+validity period, days left, renewal status and, if recorded at issuance,
+the challenge type used for each name.
+
+Status exits with a non-zero code if any certificate has already expired.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+
+	statusJSON bool
+)
+
+func init() {
+	cmdStatus.flag.Var(&certRenewAt, "renew-at", "")
+	cmdStatus.flag.BoolVar(&statusJSON, "json", statusJSON, "")
+}
+
+// certStatus is the renewal status of a certificate file.
+type certStatus struct {
+	Domain     string            `json:"domain"`
+	File       string            `json:"file"`
+	Key        string            `json:"key,omitempty"` // if alongside File
+	Meta       string            `json:"meta,omitempty"`
+	Names      []string          `json:"names"`
+	Serial     string            `json:"serial"`
+	Issuer     string            `json:"issuer"`
+	NotBefore  time.Time         `json:"notBefore"`
+	Expiry     time.Time         `json:"notAfter"`
+	DaysLeft   int               `json:"daysLeft"`
+	DueAt      time.Time         `json:"dueAt"`
+	Expired    bool              `json:"expired"`
+	Due        bool              `json:"due"`
+	Challenges map[string]string `json:"challenges,omitempty"`
+}
+
+func runStatus(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	if err := checkConfigDir(); err != nil {
+		fatalf("%v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(configDir, "*.crt"))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var list []*certStatus
+	for _, f := range files {
+		crts, err := readCerts(f)
+		if err != nil {
+			logf("skipping %s: %v", f, err)
+			continue
+		}
+		s := newCertStatus(f, crts[0], time.Now())
+		if _, err := os.Stat(s.Key); err != nil {
+			s.Key = ""
+		}
+		if _, err := os.Stat(metaPath(f)); err == nil {
+			s.Meta = metaPath(f)
+		}
+		if m, err := readMeta(f); err != nil {
+			logf("%s: %v", s.Meta, err)
+		} else {
+			s.Challenges = m.Challenges
+		}
+		list = append(list, s)
+	}
+	if statusJSON {
+		if list == nil {
+			list = []*certStatus{}
+		}
+		printJSON(list)
+	} else {
+		printStatus(os.Stdout, list, time.Now())
+	}
+	for _, s := range list {
+		if s.Expired {
+			setExitStatus(1)
+		}
+	}
+}
+
+// newCertStatus returns the status of crt, stored in file, at time now.
+func newCertStatus(file string, crt *x509.Certificate, now time.Time) *certStatus {
+	due := certRenewAt.renewAt(crt)
+	return &certStatus{
+		Domain:    strings.TrimSuffix(filepath.Base(file), ".crt"),
+		File:      file,
+		Key:       strings.TrimSuffix(file, ".crt") + ".key",
+		Names:     certDomains(crt),
+		Serial:    fmt.Sprintf("%X", crt.SerialNumber),
+		Issuer:    crt.Issuer.CommonName,
+		NotBefore: crt.NotBefore,
+		Expiry:    crt.NotAfter,
+		DaysLeft:  days(crt.NotAfter.Sub(now)),
+		DueAt:     due,
+		Expired:   !now.Before(crt.NotAfter),
+		Due:       !now.Before(due),
+	}
+}
+
+// printStatus outputs list into w using tabwriter.
+func printStatus(w io.Writer, list []*certStatus, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tEXPIRY\tDAYS LEFT\tDUE\tDUE AT")
+	for _, s := range list {
+		due := "no"
+		switch {
+		case s.Expired:
+			due = "expired"
+		case s.Due:
+			due = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", s.Domain, s.Expiry.Format(time.RFC3339),
+			days(s.Expiry.Sub(now)), due, s.DueAt.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var (
+	cmdTerms = &command{
+		run:       runTerms,
+		UsageLine: "terms [-c config]",
+		Short:     "verify the agreed terms of service did not change",
+		Long: `
+Terms fetches the CA terms of service agreed to by the account selected
+with -ca, and compares its SHA-256 with the one recorded in {{.AccountFile}}
+when the terms were accepted. CAs may change the document served at the
+same URL, so this proves whether the agreed version is still current.
+
+The command exits with a non-zero code if the terms changed.
+The hash is recorded by the reg and init commands; accounts registered
+with earlier versions, or without accepting terms, have none.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+)
+
+func runTerms(args []string) {
+	if len(args) != 0 {
+		fatalf("unexpected arguments")
+	}
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.AgreedTerms == "" {
+		fatalf("no terms agreed to for %s", uc.URI)
+	}
+	if uc.TermsHash == "" {
+		fatalf("no terms hash recorded for %s", uc.URI)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	h, err := termsHash(ctx, uc.AgreedTerms)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if h != uc.TermsHash {
+		var at string
+		if t := uc.TermsAcceptedAt; t != nil {
+			at = " at " + t.Format(time.RFC3339)
+		}
+		errorf("terms at %s changed since accepted%s", uc.AgreedTerms, at)
+		return
+	}
+	fmt.Printf("terms at %s match the agreed version\n", uc.AgreedTerms)
+}
+
+// termsHash returns the hex encoded SHA-256 of the terms document at url.
+func termsHash(ctx context.Context, url string) (string, error) {
+	b, err := fetch(ctx, url, 10<<20)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+var (
+	cmdTestkey = &command{
+		run:       runTestkey,
+		UsageLine: "testkey [-c config] [file]",
+		Short:     "verify a private key can sign",
+		Long: `
+Testkey loads the account key of the account selected with -ca, or the key
+in file, signs a random message with it using the scheme of ACME requests
+for the key type, and verifies the signature with the public key.
+An RSA key is also checked for consistency of its components.
+
+This catches corrupted or mismatched keys, such as a truncated or edited
+key file, before a CA request fails with an unclear error.
+Nothing is sent to a CA.
+
+Testkey exits with a non-zero code if the check fails.
+`,
+	}
+)
+
+func runTestkey(args []string) {
+	var path string
+	switch len(args) {
+	case 0:
+		uc, err := readConfig()
+		if err != nil {
+			fatalf("read config: %v", err)
+		}
+		path = uc.keyPath()
+	case 1:
+		path = args[0]
+	default:
+		fatalf("too many arguments")
+	}
+	k, err := readKey(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := testSign(k); err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	fmt.Printf("%s: %s key can sign\n", path, keyDesc(k.Public()))
+}
+
+// testSign signs a random message with k and verifies the signature,
+// using RS256 for RSA keys and ES256, ES384 or ES512 by curve size
+// for ECDSA keys, as JWS does.
+func testSign(k crypto.Signer) error {
+	msg := make([]byte, 32)
+	if _, err := rand.Read(msg); err != nil {
+		return err
+	}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		if err := k.Validate(); err != nil {
+			return err
+		}
+		h := crypto.SHA256.New()
+		h.Write(msg)
+		d := h.Sum(nil)
+		sig, err := k.Sign(rand.Reader, d, crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(&k.PublicKey, crypto.SHA256, d, sig)
+	case *ecdsa.PrivateKey:
+		hash := crypto.SHA256
+		switch k.Curve.Params().BitSize {
+		case 384:
+			hash = crypto.SHA384
+		case 521:
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(msg)
+		d := h.Sum(nil)
+		sig, err := k.Sign(rand.Reader, d, hash)
+		if err != nil {
+			return err
+		}
+		if !ecdsa.VerifyASN1(&k.PublicKey, d, sig) {
+			return errors.New("signature does not verify with the public key")
+		}
+		return nil
+	case ed25519.PrivateKey:
+		sig, err := k.Sign(rand.Reader, msg, crypto.Hash(0))
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(k.Public().(ed25519.PublicKey), msg, sig) {
+			return errors.New("signature does not verify with the public key")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", k)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+)
+
+func TestTestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []crypto.Signer{rsaKey}
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		k, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := testSign(k); err != nil {
+			t.Errorf("%s: %v", keyDesc(k.Public()), err)
+		}
+	}
+
+	bad := *rsaKey
+	bad.D = new(big.Int).Add(rsaKey.D, big.NewInt(2))
+	if err := testSign(&bad); err == nil {
+		t.Error("corrupted RSA key: nil error")
+	}
+}
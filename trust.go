@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+var (
+	cmdTrust = &command{
+		run:       runTrust,
+		UsageLine: "trust [-c config]",
+		Short:     "print account key fingerprints",
+		Long: `
+Trust prints fingerprints of the account public key, suitable for
+recording the account identity in a runbook or an inventory system.
+
+The following fingerprints are printed:
+
+	JWK thumbprint, as defined by RFC 7638
+	SHA-256 of the DER encoded SubjectPublicKeyInfo, in base64
+	the same SHA-256, as colon-delimited hex
+
+Both RSA and EC keys are supported.
+No request is made to the CA.
+
+Default location of the config dir is
+{{.ConfigDir}}.
+`,
+	}
+)
+
+func runTrust([]string) {
+	uc, err := readConfig()
+	if err != nil {
+		fatalf("read config: %v", err)
+	}
+	if uc.key == nil {
+		fatalf("no key found for %s", uc.URI)
+	}
+	if err := printFingerprints(os.Stdout, uc.key.Public(), uc.keyPath()); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// printFingerprints outputs fingerprints of pub into w using fieldWriter.
+func printFingerprints(w io.Writer, pub crypto.PublicKey, kp string) error {
+	thumb, err := acme.JWKThumbprint(pub)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(der)
+	hexsum := make([]string, len(sum))
+	for i, b := range sum {
+		hexsum[i] = hex.EncodeToString([]byte{b})
+	}
+
+	tw := fieldWriter(w)
+	fmt.Fprintln(tw, "Key:\t", kp)
+	fmt.Fprintln(tw, "JWK:\t", thumb)
+	fmt.Fprintln(tw, "SPKI:\t", "sha256/"+base64.StdEncoding.EncodeToString(sum[:]))
+	fmt.Fprintln(tw, "SHA256:\t", strings.ToUpper(strings.Join(hexsum, ":")))
+	return tw.Flush()
+}
@@ -13,8 +13,8 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -23,12 +23,24 @@ import (
 var (
 	cmdUpdate = &command{
 		run:       runUpdate,
-		UsageLine: "update [-c config] [-accept] [contact [contact ...]]",
+		UsageLine: "update [-c config] [-accept] [-clear | [-email addr] [-contact uri] [contact [contact ...]]]",
 		Short:     "update account data",
 		Long: `
 Update modifies account contact info and accepts the current CA
 service agreement which can be seen using whoami command.
 
+The account is read back from the CA after the update, and the stored
+config reflects exactly what the CA returned. A warning is printed for
+each contact the CA dropped or altered.
+
+If contacts are specified, they replace the existing ones; see acme help reg
+for the accepted forms.
+
+The -clear argument removes all contacts instead. Some CAs require at least
+one contact and refuse this. If the CA refuses an update, or still has
+contacts after a clear, the problem is reported and the stored config
+is left unchanged.
+
 Use -accept argument to indicate that the account holder agrees with
 the proposed CA's Terms and Conditions (the agreement).
 
@@ -37,14 +49,26 @@ Default location of the config dir is
 `,
 	}
 
-	updateAccept bool
+	updateAccept   bool
+	updateClear    bool
+	updateContacts contactList
 )
 
 func init() {
 	cmdUpdate.flag.BoolVar(&updateAccept, "accept", updateAccept, "")
+	cmdUpdate.flag.BoolVar(&updateClear, "clear", updateClear, "")
+	cmdUpdate.flag.Var(&updateContacts, "contact", "")
+	cmdUpdate.flag.Var(emailList{&updateContacts}, "email", "")
 }
 
 func runUpdate(args []string) {
+	contact, err := contacts(args, updateContacts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if updateClear && len(contact) != 0 {
+		fatalf("-clear cannot be used with contacts")
+	}
 	uc, err := readConfig()
 	if err != nil {
 		fatalf("read config: %v", err)
@@ -53,29 +77,88 @@ func runUpdate(args []string) {
 		fatalf("no key found for %s", uc.URI)
 	}
 
-	client := acme.Client{Key: uc.key}
+	client := newClient(uc.key, "")
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
+	if err := updateAccount(ctx, client, uc, contact); err != nil {
+		fatalf("%v", err)
+	}
+	printAccount(os.Stdout, &uc.Account, uc.keyPath())
+}
 
+// updateAccount updates the account of uc at the CA, replacing its
+// contacts with contact unless it is empty, or removing them all with
+// -clear, and stores the account read back from the CA.
+// The stored config is not modified if an error is returned.
+func updateAccount(ctx context.Context, client *acme.Client, uc *userConfig, contact []string) error {
+	a := uc.Account
 	if updateAccept {
-		a, err := client.GetReg(ctx, uc.URI)
+		cur, err := client.GetReg(ctx, uc.URI)
 		if err != nil {
-			fatalf(err.Error())
+			return err
 		}
-		uc.Account = *a
-		uc.AgreedTerms = a.CurrentTerms
+		a = *cur
+		a.AgreedTerms = cur.CurrentTerms
 	}
-	if len(args) != 0 {
-		uc.Contact = args
+	if len(contact) != 0 {
+		a.Contact = contact
 	}
 
-	a, err := client.UpdateReg(ctx, &uc.Account)
+	var err error
+	if updateClear {
+		// the acme package omits an empty contact list,
+		// which would leave the contacts unchanged
+		a.Contact = nil
+		err = postJWS(ctx, client, uc.URI, struct {
+			Resource  string   `json:"resource"`
+			Contact   []string `json:"contact"`
+			Agreement string   `json:"agreement,omitempty"`
+		}{"reg", []string{}, a.AgreedTerms})
+	} else {
+		_, err = client.UpdateReg(ctx, &a)
+	}
+	if err != nil {
+		if e, ok := err.(*acme.Error); ok {
+			return fmt.Errorf("CA refused the update: %s (%s); stored contacts kept", e.Detail, e.ProblemType)
+		}
+		return err
+	}
+	// the CA may drop or normalize some of the contacts;
+	// store exactly what it has
+	got, err := client.GetReg(ctx, uc.URI)
 	if err != nil {
-		fatalf(err.Error())
+		return err
+	}
+	if updateClear && len(got.Contact) != 0 {
+		return fmt.Errorf("CA kept contacts %q after clearing; stored contacts kept", got.Contact)
+	}
+	for _, c := range diffContacts(a.Contact, got.Contact) {
+		logf("contact %s was not stored by the CA", c)
+	}
+	for _, c := range diffContacts(got.Contact, a.Contact) {
+		logf("CA stored unrequested contact %s", c)
 	}
-	uc.Account = *a
+	uc.Account = *got
 	if err := writeConfig(uc); err != nil {
-		fatalf("write config: %v", err)
+		return fmt.Errorf("write config: %v", err)
+	}
+	return nil
+}
+
+// diffContacts returns the contacts in a which are not in b.
+func diffContacts(a, b []string) []string {
+	var res []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, x)
+		}
 	}
-	printAccount(os.Stdout, &uc.Account, filepath.Join(configDir, accountKey))
+	return res
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestUpdateClearRefused(t *testing.T) {
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type": "urn:acme:error:malformed", "detail": "at least one contact is required"}`)
+	}))
+	defer refusing.Close()
+	keeping := newTestCA("id")
+	defer keeping.Close()
+
+	dir, err := ioutil.TempDir("", "acme-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, c bool) { configDir, updateClear = d, c }(configDir, updateClear)
+	configDir = dir
+	updateClear = true
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ca   *httptest.Server
+		want string
+	}{
+		{refusing, "at least one contact is required"},
+		{keeping, "kept contacts"},
+	}
+	for _, test := range tests {
+		contact := []string{"mailto:admin@example.org"}
+		uc := &userConfig{
+			Account: acme.Account{URI: test.ca.URL + "/reg/id", Contact: contact},
+			CA:      test.ca.URL + "/directory",
+			key:     key,
+		}
+		if err := writeConfig(uc); err != nil {
+			t.Fatal(err)
+		}
+		err := updateAccount(context.Background(), newClient(key, ""), uc, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: updateAccount error %v; want %q", test.want, err, test.want)
+		}
+		stored, err := readConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stored.Contact, contact) {
+			t.Errorf("%s: stored contacts %q; want %q", test.want, stored.Contact, contact)
+		}
+	}
+}
+
+func TestUpdateClearSendsEmptyContacts(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "acme-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string, c bool) { configDir, updateClear = d, c }(configDir, updateClear)
+	configDir = dir
+	updateClear = true
+
+	for _, key := range []crypto.Signer{ec, rk} {
+		contact := []string{"mailto:admin@example.org"}
+		var bodies []string
+		ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Replay-Nonce", "nonce")
+			if r.Method == "HEAD" {
+				return
+			}
+			payload, err := verifyJWS(r, key.Public())
+			if err != nil {
+				t.Errorf("%s: %v", keyDesc(key.Public()), err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			bodies = append(bodies, string(payload))
+			var req struct {
+				Contact *[]string `json:"contact"`
+			}
+			json.Unmarshal(payload, &req)
+			if req.Contact != nil {
+				contact = *req.Contact
+			}
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string][]string{"contact": contact})
+		}))
+		uc := &userConfig{
+			Account: acme.Account{URI: ca.URL + "/reg/id", Contact: contact},
+			CA:      ca.URL + "/directory",
+			key:     key,
+		}
+		if err := writeConfig(uc); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateAccount(context.Background(), newClient(key, ""), uc, nil); err != nil {
+			t.Errorf("%s: updateAccount: %v", keyDesc(key.Public()), err)
+		}
+		ca.Close()
+		if len(bodies) == 0 || bodies[0] != `{"resource":"reg","contact":[]}` {
+			t.Errorf("%s: CA received %q; want an empty contact list first", keyDesc(key.Public()), bodies)
+		}
+		stored, err := readConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored.Contact) != 0 {
+			t.Errorf("%s: stored contacts %q; want none", keyDesc(key.Public()), stored.Contact)
+		}
+	}
+}
+
+// verifyJWS checks the JWS request body of r against pub and the JWK
+// in its protected header, and returns the decoded payload.
+func verifyJWS(r *http.Request, pub crypto.PublicKey) ([]byte, error) {
+	var jws struct {
+		Protected, Payload, Signature string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	phead, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, err
+	}
+	var h struct {
+		Alg   string
+		JWK   json.RawMessage
+		Nonce string
+	}
+	if err := json.Unmarshal(phead, &h); err != nil {
+		return nil, err
+	}
+	// RFC 7638 thumbprints hash the JWK members as they are sent
+	tp, err := acme.JWKThumbprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(h.JWK)
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != tp || h.Nonce != "nonce" {
+		return nil, fmt.Errorf("protected header %s", phead)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return nil, err
+	}
+	hash := crypto.SHA256
+	if h.Alg == "ES384" {
+		hash = crypto.SHA384
+	}
+	d := hash.New()
+	d.Write([]byte(jws.Protected + "." + jws.Payload))
+	ok := false
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		ok = h.Alg == "RS256" && rsa.VerifyPKCS1v15(pub, hash, d.Sum(nil), sig) == nil
+	case *ecdsa.PublicKey:
+		n := len(sig) / 2
+		r, s := new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])
+		ok = h.Alg == "ES384" && ecdsa.Verify(pub, d.Sum(nil), r, s)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bad %s signature", h.Alg)
+	}
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"text/template"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -47,6 +48,22 @@ Default location of the account config dir is
 
 Use -c argument with any acme command to override the default location
 of the config dir. Alternatively, set ACME_CONFIG environment variable.
+
+The config may hold accounts at multiple CAs. Registering at a CA other than
+the one of the default account adds another account to the config.
+Use -ca argument with any acme command to select the account by its CA
+discovery URL or alias; by default, the first registered account is used.
+Commands issuing certificates also use the directory of the selected CA.
+
+If ACME_KEY_PASS environment variable is set when an account key is
+generated, the key is stored encrypted with its value as the passphrase.
+Encrypted keys are decrypted with the same variable when read, so it must
+also be set for unattended runs. Keys are stored as PKCS#8 ENCRYPTED
+PRIVATE KEY blocks, using PBKDF2 and AES-256, which OpenSSL and other
+tools can read too. Certificate keys and metadata are only encrypted
+with the -encrypt flag of the cert and rekey commands, since servers
+using the certificates usually need to read the keys.
+Use the passwd command to change the passphrase of an existing key.
 `,
 	}
 
@@ -63,6 +80,62 @@ A directory alias can also be used. Currently defined aliases are:
 
 For more information about the spec see
 https://tools.ietf.org/html/draft-ietf-acme-acme.
+`,
+	}
+
+	helpFlags = &command{
+		UsageLine: "flags",
+		Short:     "flags common to all commands",
+		Long: `
+The following flags are accepted by every acme command:
+
+	-c dir
+		Config dir location. See acme help account.
+	-ca url
+		CA discovery URL or alias selecting the account to use,
+		if the config holds accounts at multiple CAs.
+		See acme help account.
+	-account-file name
+		Name of the config file in the config dir.
+		The default is {{.AccountFile}}.
+	-account-key-file name
+		Name of the account key file in the config dir,
+		used unless the config records another one.
+		The default is {{.AccountKey}}.
+	-check-key
+		Check the account key before use. A warning is printed
+		if the key type or size is one CAs commonly reject, or if
+		the account cannot be looked up with the key.
+	-user-agent string
+		User-Agent header sent with each request to a CA.
+		The default is "{{.UserAgent}}".
+	-proxy url
+		HTTP proxy for requests to a CA, such as
+		http://proxy.example.com:3128. By default the proxy is
+		taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+		environment variables.
+	-ca-cert file
+		PEM file of root certificates to trust for TLS connections
+		to the CA, in addition to the system roots. Together with
+		-ca or -d, this allows using a local test CA.
+	-http-timeout dur
+		Time limit for connecting to a CA, the TLS handshake and
+		waiting for each response, separately from the overall time
+		a command may take. A hung connection then fails promptly.
+		Zero disables the limit. The default is {{.HTTPTimeout}}.
+	-resolver host:port
+		DNS server for the program's own lookups, such as the dns-01
+		propagation check, the http-01 self-check and the doctor checks,
+		instead of the system resolver. The port defaults to 53.
+		It does not change how the CA resolves names during validation.
+	-log-file path
+		File to append log messages to, in addition to the standard
+		error, for unattended runs. Each line is prefixed with
+		a timestamp and the command name.
+	-log-max-size bytes
+		Size above which the log file is moved to path.1 and
+		a new one is started. Zero disables rotation.
+		The default is {{.LogMaxSize}}.
 `,
 	}
 )
@@ -145,12 +218,46 @@ func help(args []string) {
 				AccountKey   string
 				DefaultDisco string
 				DiscoAliases map[string]string
+				UserAgent    string
+				LogMaxSize   int64
+				RenewAt      string
+
+				MaxCertLifetime int
+				DNSTimeout      time.Duration
+				RevokeReasons   string
+				JournalFile     string
+				KeyPassEnv      string
+				NewKeyPassEnv   string
+				CRLMaxAge       time.Duration
+				CRLMaxSize      int
+
+				SelfCheckAttempts int
+				SelfCheckInterval time.Duration
+				CertExpiry        time.Duration
+				HTTPTimeout       time.Duration
 			}{
 				ConfigDir:    configDir,
 				AccountFile:  accountFile,
 				AccountKey:   accountKey,
 				DefaultDisco: defaultDisco,
 				DiscoAliases: discoAliases,
+				LogMaxSize:   logMaxSize,
+				UserAgent:    userAgent,
+				RenewAt:      certRenewAt.String(),
+
+				MaxCertLifetime: days(infoMaxLifetime),
+				DNSTimeout:      certDNSTimeout,
+				RevokeReasons:   revokeReasonNames(),
+				JournalFile:     journalFile,
+				KeyPassEnv:      keyPassEnv,
+				NewKeyPassEnv:   newKeyPassEnv,
+				CRLMaxAge:       crlMaxAge,
+				CRLMaxSize:      crlMaxSize >> 20,
+
+				SelfCheckAttempts: certSelfCheckAttempts,
+				SelfCheckInterval: certSelfCheckInterval,
+				CertExpiry:        certExpiry,
+				HTTPTimeout:       clientHTTPTimeout,
 			}
 			tmpl(os.Stdout, cmd.Long, data)
 			return
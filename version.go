@@ -33,6 +33,7 @@ The binary built with "go get" will not have the version subcommand.
 func init() {
 	// Insert "acme version" at the top of the commands.
 	commands = append([]*command{cmdVersion}, commands...)
+	userAgent += "/" + version
 }
 
 func runVersion(args []string) {
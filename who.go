@@ -13,8 +13,9 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -23,7 +24,7 @@ import (
 var (
 	cmdWho = &command{
 		run:       runWhoami,
-		UsageLine: "whoami [-c config]",
+		UsageLine: "whoami [-c config] [-remote [-fix]]",
 		Short:     "display info about the key holder",
 		Long: `
 Whoami makes a request to the ACME server signed with a private key
@@ -31,11 +32,24 @@ found in the config file and displays the formatted results.
 
 It is a simple way to verify the validity of an account key.
 
+With -remote, the account data returned by the CA is also compared
+to the local config, and the differences are printed. This reveals
+changes made to the account by other tools. Use -fix to replace
+the local config with the CA's version.
+
 Default location of the config dir is {{.ConfigDir}}.
 `,
 	}
+
+	whoRemote bool
+	whoFix    bool
 )
 
+func init() {
+	cmdWho.flag.BoolVar(&whoRemote, "remote", whoRemote, "")
+	cmdWho.flag.BoolVar(&whoFix, "fix", whoFix, "")
+}
+
 func runWhoami([]string) {
 	uc, err := readConfig()
 	if err != nil {
@@ -48,10 +62,49 @@ func runWhoami([]string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client := acme.Client{Key: uc.key}
+	client := newClient(uc.key, "")
 	a, err := client.GetReg(ctx, uc.URI)
 	if err != nil {
 		fatalf(err.Error())
 	}
-	printAccount(os.Stdout, a, filepath.Join(configDir, accountKey))
+	printAccount(os.Stdout, a, uc.keyPath())
+	if !whoRemote {
+		return
+	}
+
+	diff := accountDiff(&uc.Account, a)
+	if len(diff) == 0 {
+		fmt.Println("Local config matches the CA.")
+		return
+	}
+	fmt.Println("Local config differs from the CA:")
+	for _, d := range diff {
+		fmt.Println("\t" + d)
+	}
+	if whoFix {
+		uc.Account = *a
+		if err := writeConfig(uc); err != nil {
+			fatalf("write config: %v", err)
+		}
+		return
+	}
+	setExitStatus(1)
+}
+
+// accountDiff describes the fields that differ between
+// the local and remote versions of an account.
+func accountDiff(local, remote *acme.Account) []string {
+	var res []string
+	add := func(name, l, r string) {
+		if l != r {
+			res = append(res, fmt.Sprintf("%s: local %q, CA %q", name, l, r))
+		}
+	}
+	add("Contact", strings.Join(local.Contact, ", "), strings.Join(remote.Contact, ", "))
+	add("Agreed terms", local.AgreedTerms, remote.AgreedTerms)
+	add("Current terms", local.CurrentTerms, remote.CurrentTerms)
+	add("Authz", local.Authz, remote.Authz)
+	add("Authorizations", local.Authorizations, remote.Authorizations)
+	add("Certificates", local.Certificates, remote.Certificates)
+	return res
 }